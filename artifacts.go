@@ -0,0 +1,165 @@
+package cntest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ArtifactsDirEnv names the env var holding the root folder for failed test artifacts
+// When it is set, ExecuteWithRunningContainer saves debugging artifacts for failing tests
+// under $CNTEST_ARTIFACTS_DIR/<test name>/<container name>
+const ArtifactsDirEnv = "CNTEST_ARTIFACTS_DIR"
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// execTranscript records a command run with RunCmd and the output read from it
+type execTranscript struct {
+	cmd    []string
+	mu     sync.Mutex
+	output bytes.Buffer
+}
+
+// Write records output as the caller reads it
+func (e *execTranscript) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.output.Write(p)
+}
+
+func (e *execTranscript) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return fmt.Sprintf("$ %s\n%s\n", strings.Join(e.cmd, " "), e.output.String())
+}
+
+// containerConfig is the effective configuration saved as an artifact
+type containerConfig struct {
+	Config     *container.Config
+	HostConfig *container.HostConfig
+}
+
+// ArtifactsDir returns the folder artifacts for this test would be saved in
+// or "" if CNTEST_ARTIFACTS_DIR is not set
+func ArtifactsDir(t testing.TB) string {
+	root := os.Getenv(ArtifactsDirEnv)
+	if len(root) == 0 {
+		return ""
+	}
+	return filepath.Join(root, safePathName(t.Name()))
+}
+
+// CaptureArtifacts saves the container artifacts if the test has failed
+// and CNTEST_ARTIFACTS_DIR is set. Call it before the container is removed.
+func CaptureArtifacts(t testing.TB, c *Container) {
+	if !t.Failed() {
+		return
+	}
+	captureArtifacts(t, c)
+}
+
+// captureArtifacts saves the container artifacts if CNTEST_ARTIFACTS_DIR is set
+func captureArtifacts(t testing.TB, c *Container) {
+	testDir := ArtifactsDir(t)
+	if len(testDir) == 0 {
+		return
+	}
+	dir := filepath.Join(testDir, safePathName(c.ContainerName()))
+	if err := c.SaveArtifacts(dir); err != nil {
+		t.Logf("Some artifacts for %s could not be saved: %v", c.ContainerName(), err)
+	} else {
+		t.Logf("Saved artifacts for %s to %s", c.ContainerName(), dir)
+	}
+}
+
+// SaveArtifacts writes everything useful for debugging the container into dir:
+// its logs, docker inspect output, effective config, props, exec transcripts
+// and any ArtifactPaths copied out of the container as tar files.
+// It carries on past failures and returns the first error.
+func (c *Container) SaveArtifacts(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	logs, err := c.Logs()
+	record(err)
+	record(os.WriteFile(filepath.Join(dir, "logs.txt"), []byte(logs), 0o644))
+
+	if id, err := c.id(); err != nil {
+		record(err)
+	} else if _, raw, err := API().ContainerInspectWithRaw(context.Background(), id, false); err == nil {
+		record(os.WriteFile(filepath.Join(dir, "inspect.json"), raw, 0o644))
+	} else {
+		record(err)
+	}
+
+	c.mu.RLock()
+	record(writeJSON(filepath.Join(dir, "config.json"), containerConfig{c.Config, c.HostConfig}))
+	c.mu.RUnlock()
+	record(writeJSON(filepath.Join(dir, "props.json"), c.Props))
+
+	c.mu.RLock()
+	execTranscripts := c.execTranscripts
+	c.mu.RUnlock()
+	if len(execTranscripts) > 0 {
+		var transcripts strings.Builder
+		for _, transcript := range execTranscripts {
+			transcripts.WriteString(transcript.String())
+		}
+		record(os.WriteFile(filepath.Join(dir, "exec.log"), []byte(transcripts.String()), 0o644))
+	}
+
+	for _, path := range c.ArtifactPaths {
+		record(c.copyOut(path, filepath.Join(dir, safePathName(string(path))+".tar")))
+	}
+	return firstErr
+}
+
+// copyOut saves a path from the container to a tar file on the host
+func (c *Container) copyOut(path ContainerPath, tarFile string) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	reader, _, err := API().CopyFromContainer(context.Background(), id, string(path))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	out, err := os.Create(tarFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, reader)
+	return err
+}
+
+func writeJSON(file string, value any) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0o644)
+}
+
+// safePathName turns test and container names into something usable as a folder name
+func safePathName(name string) string {
+	return strings.Trim(unsafePathChars.ReplaceAllString(name, "_"), "_")
+}
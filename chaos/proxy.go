@@ -0,0 +1,335 @@
+package chaos
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cybernostics/cntest"
+)
+
+// chunkSize is the most we read in one go when copying between the client and upstream
+const chunkSize = 32 * 1024
+
+// Toxics are the network problems the proxy is currently simulating
+type Toxics struct {
+	// Latency delays every chunk of data in both directions
+	Latency time.Duration
+	// Jitter randomly varies the latency by up to +/- this much
+	Jitter time.Duration
+	// BytesPerSecond limits the bandwidth in each direction. 0 means unlimited
+	BytesPerSecond int
+	// ResetPeer resets connections with a TCP RST as soon as they are made or data flows
+	ResetPeer bool
+	// Timeout stops data flowing while leaving connections open, like a half-open
+	// connection or a network partition. Clients hang until they time out
+	Timeout bool
+}
+
+// Proxy is an in-process TCP proxy which sits between a test and a container port
+// and can simulate slow and flaky networks. Toxics can be changed at any time
+// and apply to existing connections as well as new ones.
+type Proxy struct {
+	listener net.Listener
+	target   string
+
+	mu      sync.Mutex
+	toxics  Toxics
+	changed chan struct{}
+	links   map[*link]struct{}
+	closed  bool
+
+	wg sync.WaitGroup
+}
+
+// link is a proxied client connection and its upstream connection
+type link struct {
+	client   net.Conn
+	upstream net.Conn
+}
+
+// New starts a proxy on a random local port forwarding to target eg "127.0.0.1:5432"
+func New(target string) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		listener: listener,
+		target:   target,
+		changed:  make(chan struct{}),
+		links:    map[*link]struct{}{},
+	}
+	p.wg.Add(1)
+	go p.accept()
+	return p, nil
+}
+
+// ForContainer starts a proxy in front of the container's main mapped port
+// Connect to the proxy's HostPort instead of the container's to suffer the toxics
+func ForContainer(c *cntest.Container) (*Proxy, error) {
+	return New(net.JoinHostPort("127.0.0.1", c.HostPort()))
+}
+
+// Addr returns the host:port to connect to instead of the target
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// HostPort returns the proxy port, usable in place of Container.HostPort()
+func (p *Proxy) HostPort() string {
+	_, port, _ := net.SplitHostPort(p.Addr())
+	return port
+}
+
+// Toxics returns the toxics currently in effect
+func (p *Proxy) Toxics() Toxics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.toxics
+}
+
+// SetToxics replaces all the toxics in effect
+func (p *Proxy) SetToxics(toxics Toxics) {
+	p.update(func(t *Toxics) {
+		*t = toxics
+	})
+}
+
+// SetLatency delays data in both directions by latency +/- jitter
+func (p *Proxy) SetLatency(latency time.Duration, jitter time.Duration) {
+	p.update(func(t *Toxics) {
+		t.Latency = latency
+		t.Jitter = jitter
+	})
+}
+
+// SetBandwidth limits each direction to bytesPerSecond. 0 removes the limit
+func (p *Proxy) SetBandwidth(bytesPerSecond int) {
+	p.update(func(t *Toxics) {
+		t.BytesPerSecond = bytesPerSecond
+	})
+}
+
+// SetResetPeer when true resets all current connections and any new ones
+func (p *Proxy) SetResetPeer(reset bool) {
+	p.update(func(t *Toxics) {
+		t.ResetPeer = reset
+	})
+	if reset {
+		p.ResetConnections()
+	}
+}
+
+// SetTimeout when true stops data flowing but keeps connections open
+func (p *Proxy) SetTimeout(timeout bool) {
+	p.update(func(t *Toxics) {
+		t.Timeout = timeout
+	})
+}
+
+// Clear removes all toxics so the proxy passes data straight through
+func (p *Proxy) Clear() {
+	p.SetToxics(Toxics{})
+}
+
+// ResetConnections abruptly resets every connection currently open through the proxy
+func (p *Proxy) ResetConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for l := range p.links {
+		l.reset()
+	}
+}
+
+// Close stops the proxy and closes all its connections
+// Closing it again does nothing
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	for l := range p.links {
+		l.close()
+	}
+	close(p.changed)
+	p.mu.Unlock()
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+// update changes the toxics and wakes up any connections waiting on a change
+func (p *Proxy) update(change func(*Toxics)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	change(&p.toxics)
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// current returns the toxics and a channel closed when they next change
+func (p *Proxy) current() (Toxics, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.toxics, p.changed
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go p.serve(client)
+	}
+}
+
+func (p *Proxy) serve(client net.Conn) {
+	defer p.wg.Done()
+	if toxics, _ := p.current(); toxics.ResetPeer {
+		resetConn(client)
+		return
+	}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		resetConn(client)
+		return
+	}
+	l := &link{client: client, upstream: upstream}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.close()
+		return
+	}
+	p.links[l] = struct{}{}
+	p.mu.Unlock()
+
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go func() {
+		defer pipes.Done()
+		p.pipe(l, upstream, client)
+	}()
+	go func() {
+		defer pipes.Done()
+		p.pipe(l, client, upstream)
+	}()
+	pipes.Wait()
+
+	p.mu.Lock()
+	delete(p.links, l)
+	p.mu.Unlock()
+	l.close()
+}
+
+// pipe copies data from src to dst applying the toxics to each chunk
+func (p *Proxy) pipe(l *link, dst net.Conn, src net.Conn) {
+	buf := make([]byte, chunkSize)
+	for {
+		toxics, _ := p.current()
+		readSize := chunkSize
+		if toxics.BytesPerSecond > 0 && toxics.BytesPerSecond < readSize {
+			readSize = toxics.BytesPerSecond
+		}
+		n, err := src.Read(buf[:readSize])
+		if n > 0 {
+			if !p.applyToxics(l, n) {
+				return
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				l.close()
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				closeWrite(dst)
+				return
+			}
+			l.close()
+			return
+		}
+	}
+}
+
+// applyToxics holds a chunk of n bytes back as the toxics require.
+// It returns false if the connection should be dropped
+func (p *Proxy) applyToxics(l *link, n int) bool {
+	for {
+		toxics, changed := p.current()
+		if p.isClosed() {
+			return false
+		}
+		if toxics.ResetPeer {
+			l.reset()
+			return false
+		}
+		if toxics.Timeout {
+			// hold the data until the toxics change
+			<-changed
+			continue
+		}
+		delay := toxics.Latency
+		if toxics.Jitter > 0 {
+			delay += time.Duration((2*rand.Float64() - 1) * float64(toxics.Jitter))
+		}
+		if toxics.BytesPerSecond > 0 {
+			delay += time.Duration(float64(n) / float64(toxics.BytesPerSecond) * float64(time.Second))
+		}
+		if delay <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(delay):
+			return true
+		case <-changed:
+			// the toxics changed while we waited so work out the delay again
+			// eg clearing the latency lets the data through straight away
+		}
+	}
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (l *link) close() {
+	_ = l.client.Close()
+	_ = l.upstream.Close()
+}
+
+func (l *link) reset() {
+	resetConn(l.client)
+	resetConn(l.upstream)
+}
+
+// resetConn closes the connection with a TCP RST rather than a FIN
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// closeWrite passes on a half close so the other side sees EOF
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
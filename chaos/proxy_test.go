@@ -0,0 +1,157 @@
+package chaos_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/chaos"
+)
+
+// echoServer echoes back every line it is sent
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	then.AssertThat(t, err, is.Nil())
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func proxyTo(t *testing.T, target string) *chaos.Proxy {
+	proxy, err := chaos.New(target)
+	then.AssertThat(t, err, is.Nil())
+	t.Cleanup(func() {
+		_ = proxy.Close()
+	})
+	return proxy
+}
+
+func roundTrip(conn net.Conn, reader *bufio.Reader, msg string) (string, error) {
+	if _, err := conn.Write([]byte(msg + "\n")); err != nil {
+		return "", err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return line[:len(line)-1], nil
+}
+
+func TestProxyPassesDataThrough(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	conn, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn.Close()
+
+	reply, err := roundTrip(conn, bufio.NewReader(conn), "hello")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, reply, is.EqualTo("hello"))
+	then.AssertThat(t, len(proxy.HostPort()), is.GreaterThan(0))
+}
+
+func TestProxyLatency(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	conn, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	proxy.SetLatency(100*time.Millisecond, 0)
+	started := time.Now()
+	_, err = roundTrip(conn, reader, "slow")
+	then.AssertThat(t, err, is.Nil())
+	// latency applies on the way there and back
+	then.AssertThat(t, time.Since(started), is.GreaterThanOrEqualTo(200*time.Millisecond))
+
+	proxy.Clear()
+	started = time.Now()
+	_, err = roundTrip(conn, reader, "fast")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, time.Since(started), is.LessThan(100*time.Millisecond))
+}
+
+func TestProxyBandwidth(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	conn, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn.Close()
+
+	proxy.SetBandwidth(1000)
+	msg := make([]byte, 499)
+	for i := range msg {
+		msg[i] = 'x'
+	}
+	started := time.Now()
+	_, err = roundTrip(conn, bufio.NewReader(conn), string(msg))
+	then.AssertThat(t, err, is.Nil())
+	// 500 bytes each way at 1000 bytes a second
+	then.AssertThat(t, time.Since(started), is.GreaterThanOrEqualTo(time.Second))
+}
+
+func TestProxyResetPeer(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	conn, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, err = roundTrip(conn, reader, "before")
+	then.AssertThat(t, err, is.Nil())
+
+	proxy.SetResetPeer(true)
+	_, err = roundTrip(conn, reader, "after")
+	then.AssertThat(t, err, is.Not(is.Nil()))
+
+	proxy.SetResetPeer(false)
+	conn2, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn2.Close()
+	reply, err := roundTrip(conn2, bufio.NewReader(conn2), "again")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, reply, is.EqualTo("again"))
+}
+
+func TestProxyTimeoutHoldsDataUntilCleared(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	conn, err := net.Dial("tcp", proxy.Addr())
+	then.AssertThat(t, err, is.Nil())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	proxy.SetTimeout(true)
+	_, err = conn.Write([]byte("stuck\n"))
+	then.AssertThat(t, err, is.Nil())
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = reader.ReadString('\n')
+	then.AssertThat(t, errors.Is(err, os.ErrDeadlineExceeded), is.True())
+
+	proxy.SetTimeout(false)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := reader.ReadString('\n')
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, line, is.EqualTo("stuck\n"))
+}
+
+func TestProxyCloseTwice(t *testing.T) {
+	proxy := proxyTo(t, echoServer(t))
+	then.AssertThat(t, proxy.Close(), is.Nil())
+	then.AssertThat(t, proxy.Close(), is.Nil())
+}
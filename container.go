@@ -20,13 +20,19 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cybernostics/cntest/random"
 	"github.com/cybernostics/cntest/wait"
 )
 
-var api *client.Client
+var (
+	api     *client.Client
+	apiErr  error
+	apiOnce sync.Once
+)
 
 // TCPConnectFn override this to provide a tcp connect function
 type TCPConnectFn = func(timeoutSeconds int) (net.Conn, error)
@@ -52,6 +58,9 @@ type ContainerPath string
 // NOPORT constant for no port specified
 const NOPORT = HostPort("")
 
+// LabelKey is the docker label set on every container created by cntest
+const LabelKey = "cntest"
+
 // PortMap defines a host to container port mapping
 type PortMap struct {
 	Host      HostPort
@@ -111,19 +120,18 @@ func (v *VolumeMount) Mount() mount.Mount {
 type ImageRefFn func(image string, version string) string
 
 // API returns current API client or creates on first call
+// It is safe to call from multiple goroutines and they all share the one client
 func API() *client.Client {
-
-	version := os.Getenv("DOCKER_API_VERSION")
-	if len(version) == 0 {
-		os.Setenv("DOCKER_API_VERSION", "1.42")
-	}
-	if api != nil {
-		return api
-	}
-	api, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
+	apiOnce.Do(func() {
+		version := os.Getenv("DOCKER_API_VERSION")
+		if len(version) == 0 {
+			os.Setenv("DOCKER_API_VERSION", "1.42")
+		}
+		api, apiErr = client.NewClientWithOpts(client.FromEnv)
+	})
+	if apiErr != nil {
 		fmt.Println("Unable to create docker client")
-		panic(err)
+		panic(apiErr)
 	}
 	return api
 }
@@ -134,7 +142,18 @@ func API() *client.Client {
 type PropertyMap map[string]string
 
 // Container a simplified API over the docker client API
+//
+// Its methods are safe to call from multiple goroutines, so one container can be shared
+// by parallel subtests. Configure the container before starting it though.
+// The exported fields (Config, HostConfig, Props etc) aren't guarded and
+// must not be changed directly while other goroutines are using the container.
 type Container struct {
+	// mu guards the unexported fields and the Config and HostConfig maps
+	mu sync.RWMutex
+	// startMu makes sure only one Start call creates the container
+	startMu sync.Mutex
+	// postReadyRan is set once the PostReady hooks have run for this Start. Guarded by startMu
+	postReadyRan bool
 
 	// Props are metadata for a container type. eg dbusername for a db container
 	// They are not passed directly to the container environment
@@ -178,6 +197,32 @@ type Container struct {
 
 	// DBConnect fn to connect to a DB connection
 	DBConnect DBConnectFn
+
+	// Hooks run at each point in the container lifecycle eg to seed data once the container is ready
+	Hooks Hooks
+
+	// ConnectCommands returns commands for connecting to the container by hand
+	// eg a psql command line. They are printed when a container is kept for debugging
+	ConnectCommands ConnectCommandsFn
+
+	// ArtifactPaths are files or folders copied out of the container
+	// when saving artifacts for a failed test
+	ArtifactPaths []ContainerPath
+
+	// execTranscripts record the commands run with RunCmd and their output
+	execTranscripts []*execTranscript
+
+	// expectedExit is set when we stop or remove the container ourselves
+	expectedExit atomic.Bool
+	// state is where the container is in its lifecycle
+	state State
+	// pausedFrom is the state to go back to when the container is unpaused
+	pausedFrom State
+	// stateChangeFns are called on every state change
+	stateChangeFns []StateChangeFn
+
+	// lastStarted is when the container was last started or restarted in unix nanos
+	lastStarted atomic.Int64
 }
 
 // SetIfMissing sets the value if it isn't already
@@ -202,6 +247,26 @@ func (p PropertyMap) SetAll(amap PropertyMap) {
 	}
 }
 
+// randomPrefix marks the props holding the random values set by SetRandomIfMissing
+const randomPrefix = "random."
+
+// SetRandomIfMissing sets random values for the keys which aren't set, remembering them
+// so IsRandom can tell if they have been changed since
+func (p PropertyMap) SetRandomIfMissing(keys ...string) {
+	for _, key := range keys {
+		if _, ok := p[key]; !ok {
+			p[key] = random.Name()
+			p[randomPrefix+key] = p[key]
+		}
+	}
+}
+
+// IsRandom returns true if the key still has the random value set by SetRandomIfMissing
+func (p PropertyMap) IsRandom(key string) bool {
+	value, ok := p[randomPrefix+key]
+	return ok && value == p[key]
+}
+
 // FromDockerHub is the default formatter for a docker image resource
 // provide your own for private repos
 func FromDockerHub(image string, version string) string {
@@ -219,7 +284,7 @@ func NewContainer() *Container {
 
 	cnt := Container{
 		Props:               PropertyMap{},
-		Config:              &container.Config{Tty: true},
+		Config:              &container.Config{Tty: true, Labels: map[string]string{LabelKey: "true"}},
 		HostConfig:          &container.HostConfig{},
 		GetImageRepoSource:  FromDockerHub,
 		MaxStartTimeSeconds: 30,
@@ -232,6 +297,8 @@ func NewContainer() *Container {
 }
 
 // ContainerWith contstructor which takes a custom configurer
+// It returns nil if fn fails. The config isn't validated, so the image can be set afterwards.
+// Use New to get the errors and validate the config instead
 func ContainerWith(fn ContainerConfigFn) *Container {
 	cnt := NewContainer()
 
@@ -243,32 +310,42 @@ func ContainerWith(fn ContainerConfigFn) *Container {
 }
 
 // PullImage like docker pull cmd
+// It panics if the image can't be pulled. Use Pull to get the error instead
 func PullImage(img string, version string, getRepoFn ImageRefFn) {
-		images, err := API().ImageList(context.Background(), image.ListOptions{})
-	if err != nil {
+	if err := Pull(img, version, getRepoFn); err != nil {
 		panic(err)
 	}
+}
+
+// Pull pulls the image unless it is already in the local store
+func Pull(img string, version string, getRepoFn ImageRefFn) error {
+	images, err := API().ImageList(context.Background(), image.ListOptions{})
+	if err != nil {
+		return err
+	}
 	toFind := fmt.Sprintf("%s:%s", img, version)
 	for _, image := range images {
 		for _, tags := range image.RepoTags {
 			if tags == toFind {
 				fmt.Printf("Found %s in local store - skipping pull\n", toFind)
-				return
+				return nil
 			}
 		}
 	}
 	reader, err := API().ImagePull(context.Background(), getRepoFn(img, version), image.PullOptions{})
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer reader.Close()
 	_, err2 := io.Copy(os.Stdout, reader)
 	if err2 != nil {
 		_, _ = fmt.Printf("error copying image %v: %v", img, err2)
 	}
+	return nil
 }
 
 func FindContainer(name string) *Container {
-	containers, err := API().ContainerList(context.Background(), container.ListOptions{All: true,})
+	containers, err := API().ContainerList(context.Background(), container.ListOptions{All: true})
 	if err != nil {
 		return nil
 	}
@@ -277,7 +354,10 @@ func FindContainer(name string) *Container {
 			if v[1:] == name {
 
 				cnt := NewContainer()
-				cnt.Instance = container.CreateResponse{ID: c.ID}
+				cnt.setInstance(container.CreateResponse{ID: c.ID})
+				cnt.SetName(name)
+				cnt.setState(Created)
+				cnt.observe(c.State)
 				return cnt
 			}
 		}
@@ -287,15 +367,32 @@ func FindContainer(name string) *Container {
 
 // HostPort get the host port
 func (c *Container) HostPort() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	var binding = c.HostConfig.PortBindings[c.containerPort.Nat()]
 	return binding[0].HostPort
 }
 
 // SetName container name
 func (c *Container) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.name = name
 }
 
+// ID returns the docker id of the container once it has been created
+func (c *Container) ID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Instance.ID
+}
+
+func (c *Container) setInstance(instance container.CreateResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Instance = instance
+}
+
 // SetAppPort sets the main port for the container if it has one
 func (c *Container) SetAppPort(port string) *Container {
 	return c.SetPort(port, "")
@@ -305,23 +402,33 @@ func (c *Container) SetAppPort(port string) *Container {
 // Other port mappings can be added but this one is considered the big kahuna
 // for checking readiness for example
 func (c *Container) SetPort(port string, mappedHostPort string) *Container {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.containerPort = ContainerPort(port)
 	if len(mappedHostPort) == 0 {
-		c.MapToRandomHostPort(c.containerPort)
+		c.mapToRandomHostPort(c.containerPort)
 	} else {
-		c.AddPortMap(HostPort(mappedHostPort), c.containerPort)
+		c.addPortBinding(PortMap{HostPort(mappedHostPort), c.containerPort})
 	}
 	return c
 }
 
 // MapToRandomHostPort like --ports cmd switch for mapping ports
 func (c *Container) MapToRandomHostPort(containerPort ContainerPort) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mapToRandomHostPort(containerPort)
+}
+
+func (c *Container) mapToRandomHostPort(containerPort ContainerPort) {
 	c.addPortBinding(PortMap{Container: containerPort, Host: NOPORT})
-	c.AddExposedPort(containerPort)
+	c.addExposedPort(containerPort)
 }
 
 // AddPathMap like -v cmd switch for mapping paths
 func (c *Container) AddPathMap(Host HostPath, Container ContainerPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	pathMap := VolumeMount{Host, Container}
 	if c.HostConfig.Mounts == nil {
 		c.HostConfig.Mounts = make([]mount.Mount, 0)
@@ -331,6 +438,8 @@ func (c *Container) AddPathMap(Host HostPath, Container ContainerPath) {
 
 // AddPortMap like -p cmd line switch for adding port mappings
 func (c *Container) AddPortMap(host HostPort, container ContainerPort) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.addPortBinding(PortMap{host, container})
 }
 
@@ -343,6 +452,12 @@ func (c *Container) addPortBinding(portMap PortMap) {
 
 // AddExposedPort expose a container port
 func (c *Container) AddExposedPort(port ContainerPort) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addExposedPort(port)
+}
+
+func (c *Container) addExposedPort(port ContainerPort) {
 	if c.Config.ExposedPorts == nil {
 		c.Config.ExposedPorts = make(nat.PortSet)
 	}
@@ -358,49 +473,92 @@ func (c *Container) AddAllEnv(aMap map[string]string) {
 
 // AddEnv adds the value to the config
 func (c *Container) AddEnv(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Config.Env = append(c.Config.Env, fmt.Sprintf("%s=%s", key, value))
 }
 
 // Start starts the container
+// A container can only be started once. Use Resume or Restart to start it again after a stop
 func (c *Container) Start() (string, error) {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.State() != Configured {
+		return "", ErrAlreadyStarted
+	}
+
+	name := c.ContainerName()
+	c.expectedExit.Store(false)
+	c.postReadyRan = false
 
-	c.name = c.ContainerName()
+	ctx := context.Background()
+	if err := c.runHooks(ctx, "PreCreate", c.Hooks.PreCreate); err != nil {
+		return "", err
+	}
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
 
+	c.mu.RLock()
 	instance, err := API().ContainerCreate(
 		context.Background(),
 		c.Config,
 		c.HostConfig,
-		nil, nil, c.name)
+		nil, nil, name)
+	c.mu.RUnlock()
 
 	if err != nil {
 		return "", err
 	}
 
-	c.Instance = instance
-	err = API().ContainerStart(context.Background(), c.Instance.ID, container.StartOptions{})
+	c.setInstance(instance)
+	c.setState(Created)
+	if err = c.runHooks(ctx, "PostCreate", c.Hooks.PostCreate); err != nil {
+		return "", c.uncreate(ctx, err)
+	}
+	err = API().ContainerStart(ctx, instance.ID, container.StartOptions{})
 	if err != nil {
+		return "", c.uncreate(ctx, err)
+	}
+	c.setState(Running)
+	c.lastStarted.Store(time.Now().UnixNano())
+	if err = c.runHooks(ctx, "PostStart", c.Hooks.PostStart); err != nil {
 		return "", err
 	}
 
-	c.iP, err = c.InspectIPAddress()
-	if err != nil {
+	if _, err = c.IPAddress(); err != nil {
 		return "", err
 	}
 
-	fmt.Printf("Container %s is starting\n", c.Instance.ID)
+	fmt.Printf("Container %s is starting\n", instance.ID)
 
-	if len(c.Instance.Warnings) > 0 {
-		for _, warning := range c.Instance.Warnings {
+	if len(instance.Warnings) > 0 {
+		for _, warning := range instance.Warnings {
 			fmt.Println(warning)
 		}
 	}
 
-	return c.Instance.ID, nil
+	return instance.ID, nil
+}
+
+// uncreate removes a container which couldn't be started and puts it back to Configured
+// so Start can be tried again. It returns the start error
+func (c *Container) uncreate(ctx context.Context, startErr error) error {
+	c.mu.RLock()
+	id := c.Instance.ID
+	c.mu.RUnlock()
+	if err := API().ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+		return errors.Join(startErr, fmt.Errorf("unable to remove the container which didn't start: %w", err))
+	}
+	c.setInstance(container.CreateResponse{})
+	c.setState(Configured)
+	return startErr
 }
 
 // ContainerName returns the generated name for the container
 func (c *Container) ContainerName() string {
-
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if len(c.name) == 0 {
 		c.name = c.NamePrefix + "-" + random.Name()
 	}
@@ -411,13 +569,17 @@ func (c *Container) ContainerName() string {
 func (c *Container) LogsMatch(pattern string) func() (bool, error) {
 	var logPattern = regexp.MustCompile(pattern)
 	return func() (bool, error) {
+		id, err := c.id()
+		if err != nil {
+			return false, err
+		}
 		logsOptions := container.LogsOptions{
 			Details:    true,
 			ShowStderr: true,
 			ShowStdout: true,
 		}
 
-		if logsReader, err := API().ContainerLogs(context.Background(), c.Instance.ID, logsOptions); err == nil {
+		if logsReader, err := API().ContainerLogs(context.Background(), id, logsOptions); err == nil {
 			bufferedLogs := bufio.NewReader(logsReader)
 			defer logsReader.Close()
 			for {
@@ -439,15 +601,20 @@ func (c *Container) LogsMatch(pattern string) func() (bool, error) {
 
 // Logs returns the container logs as a string or error
 func (c *Container) Logs() (string, error) {
-
-	logsOptions := container.LogsOptions{
+	return c.logs(container.LogsOptions{
 		Details:    true,
 		ShowStderr: true,
 		ShowStdout: true,
 		Tail:       "all",
-	}
+	})
+}
 
-	logsReader, err := API().ContainerLogs(context.Background(), c.Instance.ID, logsOptions)
+func (c *Container) logs(logsOptions container.LogsOptions) (string, error) {
+	id, err := c.id()
+	if err != nil {
+		return "", err
+	}
+	logsReader, err := API().ContainerLogs(context.Background(), id, logsOptions)
 	if err != nil {
 		return "", err
 	}
@@ -460,35 +627,76 @@ func (c *Container) Logs() (string, error) {
 
 // AwaitLogPattern waits for the container to start based on expected log message patterns
 func (c *Container) AwaitLogPattern(timeoutSeconds int, patternRegex string) (started bool, err error) {
-	return wait.UntilTrue(timeoutSeconds, c.LogsMatch(patternRegex))
+	return c.awaitReady(timeoutSeconds, c.LogsMatch(patternRegex))
 }
 
 // AwaitIsRunning waits for the container is in the running state
 func (c *Container) AwaitIsRunning() (started bool, err error) {
-	return wait.UntilTrue(c.MaxStartTimeSeconds, c.IsRunning)
+	return c.awaitReady(c.MaxStartTimeSeconds, c.IsRunning)
 }
 
 // AwaitIsReady waits for the container is in the running state
+// If the container is not ready in time the error is a *ReadinessError
 func (c *Container) AwaitIsReady() (started bool, err error) {
-	return wait.UntilTrue(c.MaxStartTimeSeconds, c.ContainerReady)
+	if _, err := c.id(); err != nil {
+		return false, err
+	}
+	ready, err := c.awaitReady(c.MaxStartTimeSeconds, c.ContainerReady)
+	if !ready {
+		return false, err
+	}
+	if err = c.runPostReadyHooks(context.Background()); err != nil {
+		return false, err
+	}
+	c.setState(Ready)
+	return true, nil
+}
+
+// runPostReadyHooks runs the PostReady hooks the first time the container is ready after Start
+// Waiting for it again, eg after WhileDown, doesn't repeat them
+func (c *Container) runPostReadyHooks(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.postReadyRan {
+		return nil
+	}
+	if err := c.runHooks(ctx, "PostReady", c.Hooks.PostReady); err != nil {
+		return err
+	}
+	c.postReadyRan = true
+	return nil
 }
 
 // IPAddress retrieve the IP address of the running container
 func (c *Container) IPAddress() (string, error) {
-	if len(c.iP) != 0 {
-		return c.iP, nil
+	c.mu.RLock()
+	ip := c.iP
+	c.mu.RUnlock()
+	if len(ip) != 0 {
+		return ip, nil
 	}
 	ip, err := c.InspectIPAddress()
 	if err != nil {
 		return "", err
 	}
+	c.setIPAddress(ip)
+	return ip, nil
+}
+
+// setIPAddress caches the ip address. "" clears it so it is looked up again
+func (c *Container) setIPAddress(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.iP = ip
-	return c.iP, nil
 }
 
 // InspectIPAddress uses docker inspect to find out the ip address
 func (c *Container) InspectIPAddress() (string, error) {
-	inspect, err := API().ContainerInspect(context.Background(), c.Instance.ID)
+	id, err := c.id()
+	if err != nil {
+		return "", err
+	}
+	inspect, err := API().ContainerInspect(context.Background(), id)
 	if err != nil {
 		return "", err
 	}
@@ -497,22 +705,26 @@ func (c *Container) InspectIPAddress() (string, error) {
 
 // Port returns the containers port
 func (c *Container) Port() ContainerPort {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.containerPort
 }
 
 // ConnectTCP Connects tot he container port using a TCP connection
 func (c *Container) ConnectTCP(timeoutSeconds int) (net.Conn, error) {
 	var port string
+	c.mu.RLock()
 	for key := range c.Config.ExposedPorts {
 		port = key.Port()
 	}
+	c.mu.RUnlock()
 
 	ip, err := c.IPAddress()
 	if err != nil {
 		return nil, err
 	}
 
-	host := fmt.Sprintf("%s:%s", ip, port)
+	host := net.JoinHostPort(ip, port)
 	var timeout time.Duration
 	if timeoutSeconds == 0 {
 		timeout = time.Duration(10) * time.Minute
@@ -542,15 +754,12 @@ func (c *Container) Check(timeoutSeconds int) (ret bool, err error) {
 	return true, nil
 }
 
-// Stop stops the container
+// Stop kills the container with SIGKILL and waits up to timeoutSeconds for it to exit.
+// 0 doesn't wait. Use StopWith to give the container a chance to shut down gracefully
 func (c *Container) Stop(timeoutSeconds int) (ok bool, err error) {
-	timeout := 30
-	err = API().ContainerStop(context.Background(), c.Instance.ID, container.StopOptions{
-		Signal:  "SIGKILL",
-		Timeout: &timeout,
-	})
+	_, err = c.StopWith(context.Background(), StopOptions{Signal: "SIGKILL"})
 	if err != nil {
-		return
+		return false, err
 	}
 	if timeoutSeconds > 0 {
 		return c.AwaitExit(timeoutSeconds)
@@ -564,12 +773,17 @@ func (c *Container) AwaitExit(timeoutSeconds int) (ok bool, err error) {
 }
 
 // RunCmd execs the specified command and args on the container
+// The output read from the returned reader is kept for the test artifacts
 func (c *Container) RunCmd(cmd []string) (io.Reader, error) {
+	id, err := c.id()
+	if err != nil {
+		return nil, err
+	}
 	cmdConfig := types.ExecConfig{AttachStdout: true, AttachStderr: true,
 		Cmd: cmd,
 	}
 	ctx := context.Background()
-	execID, _ := API().ContainerExecCreate(ctx, c.Instance.ID, cmdConfig)
+	execID, _ := API().ContainerExecCreate(ctx, id, cmdConfig)
 	fmt.Println(execID)
 
 	res, err := API().ContainerExecAttach(ctx, execID.ID, types.ExecStartCheck{})
@@ -582,12 +796,26 @@ func (c *Container) RunCmd(cmd []string) (io.Reader, error) {
 		return nil, err
 	}
 
-	return res.Reader, nil
+	transcript := &execTranscript{cmd: cmd}
+	c.mu.Lock()
+	c.execTranscripts = append(c.execTranscripts, transcript)
+	c.mu.Unlock()
+	return io.TeeReader(res.Reader, transcript), nil
 }
 
 // Remove deletes the container permanently
 func (c *Container) Remove() error {
-	return API().ContainerRemove(context.Background(), c.Instance.ID, container.RemoveOptions{Force: true})
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	c.expectedExit.Store(true)
+	err = API().ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true})
+	if err != nil {
+		return err
+	}
+	c.setState(Removed)
+	return nil
 }
 
 // IsRemoveAfterTest true if the container should be removed
@@ -598,8 +826,7 @@ func (c *Container) IsRemoveAfterTest() bool {
 // IsRunning returns true if the container is in the started state
 // Will error if the container has already exited
 func (c *Container) IsRunning() (started bool, err error) {
-	inspect, err := API().ContainerInspect(context.Background(), c.Instance.ID)
-	status := inspect.State.Status
+	status, err := c.status()
 	if err != nil {
 		return false, err
 	}
@@ -615,18 +842,31 @@ func (c *Container) IsRunning() (started bool, err error) {
 
 // IsExited returns true if the container has exited
 func (c *Container) IsExited() (started bool, err error) {
-	inspect, err := API().ContainerInspect(context.Background(), c.Instance.ID)
+	status, err := c.status()
 	if err != nil {
 		return false, err
 	}
-	if inspect.State.Status == "exited" {
-		return true, nil
+	return status == "exited", nil
+}
+
+// status asks docker for the container status eg running, exited and updates the State to match
+func (c *Container) status() (string, error) {
+	id, err := c.id()
+	if err != nil {
+		return "", err
 	}
-	return false, nil
+	inspect, err := API().ContainerInspect(context.Background(), id)
+	if err != nil {
+		return "", err
+	}
+	c.observe(inspect.State.Status)
+	return inspect.State.Status, nil
 }
 
 // WithImage sets the image name and the default container name prefix
 func (c *Container) WithImage(image string) *Container {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Config.Image = image
 	parts := strings.Split(image, ":")
 	c.NamePrefix = parts[0]
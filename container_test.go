@@ -1,12 +1,22 @@
 package cntest_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/corbym/gocrest/is"
 	"github.com/corbym/gocrest/then"
 	"github.com/cybernostics/cntest"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
 )
 
 func TestContainer(t *testing.T) {
@@ -28,6 +38,559 @@ func TestContainer(t *testing.T) {
 
 }
 
+func TestRunToCompletion(t *testing.T) {
+	cntest.PullImage("hello-world", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("hello-world")
+	result, err := cnt.RunToCompletion(context.Background())
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, result.ExitCode, is.EqualTo(0))
+	then.AssertThat(t, result.OOMKilled, is.False())
+	then.AssertThat(t, result.Stdout, is.StringContaining("Hello from Docker!"))
+	then.AssertThat(t, result.Stderr, is.EmptyString())
+}
+
+// recordingT captures errors reported by the exit watcher
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestWatchForExitFailsTestWhenContainerDies(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "60"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	ok, err := cnt.AwaitIsRunning()
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, ok, is.True())
+
+	recorder := &recordingT{TB: t}
+	ctx, stop := cntest.WatchForExit(context.Background(), recorder, cnt)
+
+	// kill it behind cntest's back so the exit is unexpected
+	err = cntest.API().ContainerKill(context.Background(), cnt.Instance.ID, "SIGKILL")
+	then.AssertThat(t, err, is.Nil())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("context was not cancelled when the container died")
+	}
+	stop()
+	then.AssertThat(t, len(recorder.errors), is.EqualTo(1))
+	then.AssertThat(t, strings.Contains(recorder.errors[0], "exit code 137"), is.True())
+}
+
+func TestWatchForExitAfterNonFatalSignal(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "trap 'echo reloading' HUP; echo started; while true; do sleep 1; done"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	_, err = cnt.AwaitLogPattern(10, "started")
+	then.AssertThat(t, err, is.Nil())
+
+	recorder := &recordingT{TB: t}
+	ctx, stop := cntest.WatchForExit(context.Background(), recorder, cnt)
+
+	// a reload signal doesn't mean the next exit is expected
+	then.AssertThat(t, cnt.Kill("SIGHUP"), is.Nil())
+	_, err = cnt.AwaitLogPattern(10, "reloading")
+	then.AssertThat(t, err, is.Nil())
+	err = cntest.API().ContainerKill(context.Background(), cnt.Instance.ID, "SIGKILL")
+	then.AssertThat(t, err, is.Nil())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("context was not cancelled when the container crashed after SIGHUP")
+	}
+	stop()
+	then.AssertThat(t, len(recorder.errors), is.EqualTo(1))
+	then.AssertThat(t, recorder.errors[0], is.StringContaining("exit code 137"))
+}
+
+func TestReadinessErrorWhenContainerExits(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "echo going down; exit 3"}
+	// never ready, but fails once the container has exited
+	cnt.ContainerReady = func() (bool, error) {
+		_, err := cnt.IsRunning()
+		return false, err
+	}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+
+	_, err = cnt.AwaitIsReady()
+	var readyErr *cntest.ReadinessError
+	then.AssertThat(t, errors.As(err, &readyErr), is.True())
+	then.AssertThat(t, readyErr.State, is.EqualTo("exited"))
+	then.AssertThat(t, readyErr.ExitCode, is.EqualTo(3))
+	then.AssertThat(t, readyErr.Attempts, is.GreaterThan(0))
+	then.AssertThat(t, readyErr.LogTail, is.StringContaining("going down"))
+	then.AssertThat(t, readyErr.IsTimeout(), is.False())
+}
+
+func TestReadinessErrorWhenContainerTimesOut(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	cnt.MaxStartTimeSeconds = 1
+	cnt.ContainerReady = func() (bool, error) {
+		return false, nil
+	}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+
+	_, err = cnt.AwaitIsReady()
+	var readyErr *cntest.ReadinessError
+	then.AssertThat(t, errors.As(err, &readyErr), is.True())
+	then.AssertThat(t, readyErr.IsTimeout(), is.True())
+	then.AssertThat(t, readyErr.State, is.EqualTo("running"))
+	then.AssertThat(t, readyErr.Attempts, is.GreaterThan(0))
+}
+
+func TestSaveArtifacts(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "echo started; echo hello > /tmp/out.txt; sleep 60"}
+	cnt.ArtifactPaths = []cntest.ContainerPath{"/tmp/out.txt"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	_, err = cnt.AwaitLogPattern(10, "started")
+	then.AssertThat(t, err, is.Nil())
+
+	output, err := cnt.RunCmd([]string{"cat", "/tmp/out.txt"})
+	then.AssertThat(t, err, is.Nil())
+	_, err = io.ReadAll(output)
+	then.AssertThat(t, err, is.Nil())
+
+	dir := t.TempDir()
+	err = cnt.SaveArtifacts(dir)
+	then.AssertThat(t, err, is.Nil())
+	for _, file := range []string{"logs.txt", "inspect.json", "config.json", "props.json", "exec.log", "tmp_out.txt.tar"} {
+		_, err := os.Stat(filepath.Join(dir, file))
+		then.AssertThat(t, err, is.Nil())
+	}
+	transcript, err := os.ReadFile(filepath.Join(dir, "exec.log"))
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, string(transcript), is.StringContaining("$ cat /tmp/out.txt", "hello"))
+}
+
+func TestFaultInjection(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	cnt.SetAppPort("8080")
+	cntest.ExecuteWithRunningContainer(t, cnt, func(t *testing.T) {
+		hostPort := cnt.HostPort()
+
+		err := cnt.WhilePaused(func() {
+			inspect, err := cntest.API().ContainerInspect(context.Background(), cnt.Instance.ID)
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, inspect.State.Paused, is.True())
+			then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Paused))
+		})
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Ready))
+
+		err = cnt.WhileDown(func() {
+			then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Exited))
+			exited, err := cnt.IsExited()
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, exited, is.True())
+		})
+		then.AssertThat(t, err, is.Nil())
+
+		err = cnt.Restart(1)
+		then.AssertThat(t, err, is.Nil())
+		running, err := cnt.IsRunning()
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, running, is.True())
+
+		inspect, err := cntest.API().ContainerInspect(context.Background(), cnt.Instance.ID)
+		then.AssertThat(t, err, is.Nil())
+		bindings := inspect.NetworkSettings.Ports[cnt.Port().Nat()]
+		then.AssertThat(t, bindings[0].HostPort, is.EqualTo(hostPort))
+	})
+}
+
+func TestPostReadyHooksRunOncePerStart(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	cnt.SetAppPort("8080")
+	postReadyCalls := 0
+	cnt.Hooks.PostReady = append(cnt.Hooks.PostReady, func(ctx context.Context, c *cntest.Container) error {
+		postReadyCalls++
+		return nil
+	})
+	cntest.ExecuteWithRunningContainer(t, cnt, func(t *testing.T) {
+		err := cnt.WhileDown(func() {})
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Ready))
+		then.AssertThat(t, postReadyCalls, is.EqualTo(1))
+	})
+}
+
+func TestAssertGracefulShutdown(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "trap 'echo shutting down; exit 0' TERM; echo started; while true; do sleep 1; done"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	_, err = cnt.AwaitLogPattern(10, "started")
+	then.AssertThat(t, err, is.Nil())
+
+	result := cntest.AssertGracefulShutdown(t, cnt, 5*time.Second, "shutting down")
+	then.AssertThat(t, result.ExitCode, is.EqualTo(0))
+}
+
+func TestStopWithKillsAfterGracePeriod(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	// pid 1 ignores SIGTERM unless it has a handler
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+
+	result, err := cnt.StopWith(context.Background(), cntest.StopOptions{Signal: "SIGTERM", GracePeriod: time.Second})
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, result.ExitCode, is.EqualTo(137))
+}
+
+func TestStopWithZeroGracePeriodUsesDefault(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "trap 'sleep 2; echo done; exit 0' TERM; echo started; while true; do sleep 1; done"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	_, err = cnt.AwaitLogPattern(10, "started")
+	then.AssertThat(t, err, is.Nil())
+
+	// a slow shutdown finishes within docker's default 10s instead of being killed
+	result, err := cnt.StopWith(context.Background(), cntest.StopOptions{Signal: "SIGTERM"})
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, result.ExitCode, is.EqualTo(0))
+	then.AssertThat(t, result.Logs, is.StringContaining("done"))
+}
+
+func TestContainerNotStarted(t *testing.T) {
+	cnt := cntest.NewContainer().WithImage("alpine")
+	then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Configured))
+
+	_, err := cnt.Stop(0)
+	then.AssertThat(t, errors.Is(err, cntest.ErrNotStarted), is.True())
+	_, err = cnt.Logs()
+	then.AssertThat(t, errors.Is(err, cntest.ErrNotStarted), is.True())
+	_, err = cnt.IsRunning()
+	then.AssertThat(t, errors.Is(err, cntest.ErrNotStarted), is.True())
+	err = cnt.Remove()
+	then.AssertThat(t, errors.Is(err, cntest.ErrNotStarted), is.True())
+}
+
+func TestContainerWithIsLenient(t *testing.T) {
+	cnt := cntest.ContainerWith(func(c *cntest.Container) error {
+		c.SetAppPort("8080")
+		return nil
+	}).WithImage("alpine")
+	then.AssertThat(t, cnt.Config.Image, is.EqualTo("alpine"))
+
+	failed := cntest.ContainerWith(func(c *cntest.Container) error {
+		return errors.New("bad config")
+	})
+	then.AssertThat(t, failed, is.NilPtr[cntest.Container]())
+}
+
+func TestNewReportsAllProblems(t *testing.T) {
+	cnt, err := cntest.New(
+		cntest.WithPort("not-a-port"),
+		cntest.WithEnv("", "value"),
+		cntest.WithMount("./no/such/folder", "/data"),
+		cntest.WithMaxStartTime(0),
+	)
+	then.AssertThat(t, cnt, is.NilPtr[cntest.Container]())
+	then.AssertThat(t, err.Error(), is.StringContaining(`invalid port "not-a-port"`))
+	then.AssertThat(t, err.Error(), is.StringContaining("has no name"))
+	then.AssertThat(t, err.Error(), is.StringContaining("no image set"))
+	then.AssertThat(t, err.Error(), is.StringContaining("for /data does not exist"))
+	then.AssertThat(t, err.Error(), is.StringContaining("max start time must be positive"))
+
+	cnt, err = cntest.New(cntest.WithImage("alpine"), cntest.WithPort("8080"), cntest.WithLabels(map[string]string{"team": "core"}))
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, cnt.Config.Labels["team"], is.EqualTo("core"))
+	then.AssertThat(t, cnt.Port(), is.EqualTo(cntest.ContainerPort("8080")))
+}
+
+func TestImageVariants(t *testing.T) {
+	variants := cntest.ImageVariants("postgres:15", "localhost:5000/postgres")
+	then.AssertThat(t, variants[0].Name, is.EqualTo("postgres:15"))
+
+	cnt, err := cntest.New(variants[1].Options...)
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, cnt.Config.Image, is.EqualTo("localhost:5000/postgres"))
+}
+
+func TestMatrixFindsUntaggedLocalImage(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	// only in the local store, so pulling it from docker hub would fail
+	err := cntest.API().ImageTag(context.Background(), "alpine:latest", "cntest-local-only:latest")
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_, _ = cntest.API().ImageRemove(context.Background(), "cntest-local-only:latest", image.RemoveOptions{})
+	}()
+
+	sleeper := func(opts ...cntest.Option) (*cntest.Container, error) {
+		return cntest.New(append(opts, func(c *cntest.Container) error {
+			c.Config.Cmd = []string{"sleep", "600"}
+			return nil
+		})...)
+	}
+	ran := false
+	t.Run("group", func(t *testing.T) {
+		cntest.Matrix(t, cntest.ImageVariants("cntest-local-only"), sleeper, func(t *testing.T, cnt *cntest.Container) {
+			ran = true
+		})
+	})
+	then.AssertThat(t, ran, is.True())
+}
+
+func TestServerSettings(t *testing.T) {
+	dashC := func(key string, value string) []string {
+		return []string{"-c", key + "=" + value}
+	}
+	cnt, err := cntest.New(
+		cntest.WithImage("postgres"),
+		cntest.WithServerSettings("postgres", dashC, map[string]string{"fsync": "off", "work_mem": "8MB"}),
+		cntest.WithTmpfs("/data"),
+	)
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, []string(cnt.Config.Cmd), is.EqualTo([]string{"postgres", "-c", "fsync=off", "-c", "work_mem=8MB"}))
+	then.AssertThat(t, cnt.HostConfig.Tmpfs["/data"], is.EqualTo("rw"))
+
+	_, err = cntest.New(cntest.WithImage("postgres"), cntest.WithServerSettings("postgres", dashC, map[string]string{"fsync=off": ""}))
+	then.AssertThat(t, err.Error(), is.StringContaining(`invalid postgres setting name "fsync=off"`))
+
+	then.AssertThat(t, cntest.SetDataDir(cnt, "/data"), is.Nil())
+	then.AssertThat(t, cntest.SetDataDir(cnt, "/data"), is.Nil())
+	then.AssertThat(t, cntest.SetDataDir(cnt, "/disk").Error(), is.EqualTo("the data dir has already been moved to /data"))
+}
+
+func TestPreCreateHookErrorAbortsStart(t *testing.T) {
+	cnt := cntest.NewContainer().WithImage("alpine")
+	hookErr := errors.New("no config for you")
+	cnt.Hooks.PreCreate = append(cnt.Hooks.PreCreate, func(ctx context.Context, c *cntest.Container) error {
+		return hookErr
+	})
+
+	_, err := cnt.Start()
+	then.AssertThat(t, errors.Is(err, hookErr), is.True())
+	then.AssertThat(t, err.Error(), is.StringContaining("PreCreate hook 1"))
+	then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Configured))
+}
+
+func TestHooksRunInLifecycleOrder(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	var phases []string
+	record := func(phase string) cntest.HookFn {
+		return func(ctx context.Context, c *cntest.Container) error {
+			phases = append(phases, phase+":"+c.State().String())
+			return nil
+		}
+	}
+	cnt.Hooks.PreCreate = append(cnt.Hooks.PreCreate, record("PreCreate"))
+	cnt.Hooks.PostCreate = append(cnt.Hooks.PostCreate, record("PostCreate"))
+	cnt.Hooks.PostStart = append(cnt.Hooks.PostStart, record("PostStart"))
+	cnt.Hooks.PostReady = append(cnt.Hooks.PostReady, record("PostReady"))
+	cnt.Hooks.PreStop = append(cnt.Hooks.PreStop, record("PreStop"))
+	cnt.Hooks.PostStop = append(cnt.Hooks.PostStop, record("PostStop"))
+
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	_, err = cnt.AwaitIsReady()
+	then.AssertThat(t, err, is.Nil())
+	_, err = cnt.Stop(0)
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, cnt.Remove(), is.Nil())
+
+	then.AssertThat(t, strings.Join(phases, ","), is.EqualTo(
+		"PreCreate:Configured,PostCreate:Created,PostStart:Running,PostReady:Running,PreStop:Ready,PostStop:Exited"))
+}
+
+func TestContainerStateTransitions(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	var states []string
+	cnt.OnStateChange(func(_ *cntest.Container, from cntest.State, to cntest.State) {
+		states = append(states, from.String()+"->"+to.String())
+	})
+
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	_, err = cnt.Start()
+	then.AssertThat(t, errors.Is(err, cntest.ErrAlreadyStarted), is.True())
+	_, err = cnt.AwaitIsReady()
+	then.AssertThat(t, err, is.Nil())
+	_, err = cnt.Stop(0)
+	then.AssertThat(t, err, is.Nil())
+	err = cnt.Remove()
+	then.AssertThat(t, err, is.Nil())
+	_, err = cnt.Logs()
+	then.AssertThat(t, errors.Is(err, cntest.ErrRemoved), is.True())
+
+	then.AssertThat(t, states, is.EqualTo([]string{
+		"Configured->Created",
+		"Created->Running",
+		"Running->Ready",
+		"Ready->Exited",
+		"Exited->Removed",
+	}))
+}
+
+// a failed start removes the container so Start can be called again
+func TestStartCanBeRetriedAfterFailing(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"no-such-command"}
+	_, err := cnt.Start()
+	then.AssertThat(t, err, is.Not(is.Nil()))
+	then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Configured))
+
+	cnt.Config.Cmd = []string{"sleep", "600"}
+	_, err = cnt.Start()
+	then.AssertThat(t, err, is.Nil())
+	defer func() {
+		_ = cnt.Remove()
+	}()
+	then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Running))
+}
+
+func TestSnapshotIsTheSameWhenStartIsRetried(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	newAlpine := func() *cntest.Container {
+		cnt := cntest.NewContainer().WithImage("alpine")
+		cnt.Config.Cmd = []string{"sleep", "600"}
+		cnt.SetAppPort("8080")
+		cnt.Hooks.PostReady = append(cnt.Hooks.PostReady, func(ctx context.Context, c *cntest.Container) error {
+			return nil
+		})
+		then.AssertThat(t, cntest.WithSnapshot(t.Name())(cnt), is.Nil())
+		return cnt
+	}
+
+	// the first run saves the snapshot
+	first := newAlpine()
+	cntest.ExecuteWithRunningContainer(t, first, func(t *testing.T) {})
+
+	// run the PreCreate hooks as each Start attempt would
+	second := newAlpine()
+	var image string
+	for attempt := 0; attempt < 3; attempt++ {
+		for _, hook := range second.Hooks.PreCreate {
+			then.AssertThat(t, hook(context.Background(), second), is.Nil())
+		}
+		if attempt == 0 {
+			image = second.Config.Image
+		}
+		then.AssertThat(t, second.Config.Image, is.EqualTo(image))
+		then.AssertThat(t, second.Config.Image, is.StringContaining(cntest.SnapshotRepo))
+		// only the snapshot hook is left as the first hook is already in the snapshot
+		then.AssertThat(t, len(second.Hooks.PostReady), is.EqualTo(1))
+	}
+}
+
+// run with go test -race to check the container is safe to share
+func TestContainerConcurrentUse(t *testing.T) {
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.SetAppPort("8080")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			then.AssertThat(t, cntest.API(), is.Not(is.NilPtr[client.Client]()))
+			then.AssertThat(t, len(cnt.HostPort()), is.GreaterThan(0))
+			then.AssertThat(t, cnt.Port(), is.EqualTo(cntest.ContainerPort("8080")))
+			then.AssertThat(t, len(cnt.ContainerName()), is.GreaterThan(0))
+			then.AssertThat(t, cnt.State(), is.EqualTo(cntest.Configured))
+			cnt.AddEnv(fmt.Sprintf("VAR%d", i), "value")
+			cnt.AddPortMap(cntest.HostPort(fmt.Sprintf("%d", 9000+i)), cntest.ContainerPort(fmt.Sprintf("%d", 9000+i)))
+			cnt.OnStateChange(func(*cntest.Container, cntest.State, cntest.State) {})
+		}(i)
+	}
+	wg.Wait()
+	then.AssertThat(t, len(cnt.Config.Env), is.EqualTo(10))
+}
+
+func TestSharedContainerParallelSubtests(t *testing.T) {
+	cntest.PullImage("alpine", "latest", cntest.FromDockerHub)
+	cnt := cntest.NewContainer().WithImage("alpine")
+	cnt.Config.Cmd = []string{"sh", "-c", "echo started; sleep 600"}
+	cntest.ExecuteWithRunningContainer(t, cnt, func(t *testing.T) {
+		t.Run("group", func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				t.Run(fmt.Sprintf("subtest%d", i), func(t *testing.T) {
+					t.Parallel()
+					running, err := cnt.IsRunning()
+					then.AssertThat(t, err, is.Nil())
+					then.AssertThat(t, running, is.True())
+					_, err = cnt.IPAddress()
+					then.AssertThat(t, err, is.Nil())
+					logs, err := cnt.Logs()
+					then.AssertThat(t, err, is.Nil())
+					then.AssertThat(t, logs, is.StringContaining("started"))
+					output, err := cnt.RunCmd([]string{"echo", "hi"})
+					then.AssertThat(t, err, is.Nil())
+					_, err = io.ReadAll(output)
+					then.AssertThat(t, err, is.Nil())
+				})
+			}
+		})
+	})
+}
+
+func TestIsKeepOnFailure(t *testing.T) {
+	t.Setenv(cntest.KeepOnFailureEnv, "")
+	then.AssertThat(t, cntest.IsKeepOnFailure(), is.False())
+	t.Setenv(cntest.KeepOnFailureEnv, "false")
+	then.AssertThat(t, cntest.IsKeepOnFailure(), is.False())
+	t.Setenv(cntest.KeepOnFailureEnv, "1")
+	then.AssertThat(t, cntest.IsKeepOnFailure(), is.True())
+}
+
 func Test(t *testing.T) {
 	cntest.PullImage("wiremock/wiremock", "latest", cntest.FromDockerHub)
 
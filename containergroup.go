@@ -25,7 +25,7 @@ type ContainerGroup map[string]*GroupedContainer
 
 // Add adds a container to the group keyed by its name
 func (cg ContainerGroup) Add(cnt *GroupedContainer) {
-	cg[cnt.Container.name] = cnt
+	cg[cnt.Container.ContainerName()] = cnt
 }
 
 // Start starts all the containers
@@ -75,6 +75,6 @@ func (gc *GroupedContainer) Await() {
 // DependsOn is called to ensure this container wont start until these ones have
 func (gc *GroupedContainer) DependsOn(containers ...*GroupedContainer) {
 	for _, eachContainer := range containers {
-		gc.dependsOn[eachContainer.Container.name] = eachContainer
+		gc.dependsOn[eachContainer.Container.ContainerName()] = eachContainer
 	}
 }
@@ -0,0 +1,165 @@
+package cntest
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/cybernostics/cntest/wait"
+	"github.com/jmoiron/sqlx"
+)
+
+// The props the db modules store their connection settings in
+const (
+	DriverProp = "driver"
+	DBNameProp = "db"
+	DBUserProp = "dbuser"
+	DBPassProp = "dbpass"
+	// InitDBProp is the host folder of init scripts mounted by WithInitDB
+	InitDBProp = "initdb_path"
+)
+
+// DBHost is the host address for connecting to a db container from the test
+const DBHost = "127.0.0.1"
+
+// WithDBName sets the name of the database created by a db module
+func WithDBName(name string) Option {
+	return WithProps(PropertyMap{DBNameProp: name})
+}
+
+// WithDBUser sets the user and password created by a db module
+func WithDBUser(user string, password string) Option {
+	return WithProps(PropertyMap{DBUserProp: user, DBPassProp: password})
+}
+
+// WithInitDB mounts a folder of sh and sql files into /docker-entrypoint-initdb.d, where the
+// official db images run them in lexical order when the db is first initialised
+func WithInitDB(sqlPath string) Option {
+	return func(c *Container) error {
+		c.Props[InitDBProp] = sqlPath
+		c.AddPathMap(HostPath(sqlPath), ContainerPath("/docker-entrypoint-initdb.d"))
+		return nil
+	}
+}
+
+// SetFixedDBCredentials replaces missing or random db name, user and password props with "cntest"
+// so they are the same every time, eg for snapshots. Values chosen by the user are kept
+func SetFixedDBCredentials(props PropertyMap) {
+	for _, prop := range []string{DBNameProp, DBUserProp, DBPassProp} {
+		if _, ok := props[prop]; !ok || props.IsRandom(prop) {
+			props[prop] = "cntest"
+		}
+	}
+}
+
+// Database is a running db container. The mysql and postgres modules implement it
+// so tests can be written once and run against either engine
+type Database interface {
+	// Driver is the database/sql driver name. eg "postgres"
+	Driver() string
+	// DSN is the connection string for the driver
+	DSN() string
+	// Host is the address to connect to from the test
+	Host() string
+	// HostPort is the host port mapped to the db port. Container.Port is the port inside the container
+	HostPort() string
+	// User is the db user name
+	User() string
+	// Password is the db user password
+	Password() string
+	// DBName is the name of the database
+	DBName() string
+	// Open connects to the database and checks the connection works
+	Open(ctx context.Context) (*sql.DB, error)
+	// OpenSQLX connects to the database with sqlx
+	OpenSQLX(ctx context.Context) (*sqlx.DB, error)
+	// Reset empties the user tables, keeping the schema
+	Reset(ctx context.Context, opts ...ResetOption) error
+}
+
+// startingErrors are parts of the errors seen connecting to a db server which is still starting up
+var startingErrors = []string{
+	"connection refused",
+	"connection reset by peer",
+	"EOF",
+	"driver: bad connection",
+	// postgres: the database system is starting up
+	"the database system is",
+}
+
+// RetryableDBError marks errors from a db server which is still starting up as worth retrying
+// so readiness checks keep waiting, and returns other errors as they are
+func RetryableDBError(err error) error {
+	for _, starting := range startingErrors {
+		if strings.Contains(err.Error(), starting) {
+			return wait.Retryable(err)
+		}
+	}
+	return err
+}
+
+// OpenDB opens a db connection and pings it, closing it again if the ping fails
+func OpenDB(ctx context.Context, driver string, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenSQLX opens a db connection with sqlx and pings it
+func OpenSQLX(ctx context.Context, driver string, dsn string) (*sqlx.DB, error) {
+	db, err := OpenDB(ctx, driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(db, driver), nil
+}
+
+// ResetOptions are the settings for a Database Reset
+type ResetOptions struct {
+	// Exclude are tables which keep their rows. eg reference data
+	Exclude []string
+	// Reload runs after the tables are emptied. eg to load fixtures again
+	Reload func(ctx context.Context, db *sql.DB, driver string) error
+}
+
+// ResetOption changes what a Database Reset does
+type ResetOption func(*ResetOptions)
+
+// ExcludeTables keeps the rows in the tables named
+func ExcludeTables(tables ...string) ResetOption {
+	return func(o *ResetOptions) {
+		o.Exclude = append(o.Exclude, tables...)
+	}
+}
+
+// ReloadWith runs load once the tables are emptied. eg fixtures.Load
+func ReloadWith(load func(ctx context.Context, db *sql.DB, driver string) error) ResetOption {
+	return func(o *ResetOptions) {
+		o.Reload = load
+	}
+}
+
+// NewResetOptions applies the reset options in order
+func NewResetOptions(opts ...ResetOption) *ResetOptions {
+	options := &ResetOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// Excludes reports whether a table keeps its rows
+func (o *ResetOptions) Excludes(table string) bool {
+	for _, excluded := range o.Exclude {
+		if strings.EqualFold(excluded, table) {
+			return true
+		}
+	}
+	return false
+}
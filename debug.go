@@ -0,0 +1,65 @@
+package cntest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// KeepOnFailureEnv names the env var which turns on keep-on-failure mode.
+// eg CNTEST_KEEP_ON_FAILURE=1 go test ./...
+const KeepOnFailureEnv = "CNTEST_KEEP_ON_FAILURE"
+
+// TestLabelKey is the docker label holding the name of the test which created the container
+const TestLabelKey = "cntest.test"
+
+// KeepOnFailure when true leaves the containers of failing tests running so they can be debugged.
+// Containers of passing tests are cleaned up as usual. Also turned on by CNTEST_KEEP_ON_FAILURE.
+var KeepOnFailure = false
+
+// ConnectCommandsFn returns shell commands for connecting to a container by hand
+type ConnectCommandsFn func() []string
+
+// IsKeepOnFailure returns true if containers of failing tests should be kept
+func IsKeepOnFailure() bool {
+	if KeepOnFailure {
+		return true
+	}
+	switch strings.ToLower(os.Getenv(KeepOnFailureEnv)) {
+	case "", "0", "false", "no":
+		return false
+	}
+	return true
+}
+
+// DebugCommands returns ready to paste commands for poking at the container
+// The module's ConnectCommands come first, followed by the generic docker ones
+func (c *Container) DebugCommands() []string {
+	var commands []string
+	if c.ConnectCommands != nil {
+		commands = append(commands, c.ConnectCommands()...)
+	}
+	name := c.ContainerName()
+	return append(commands,
+		fmt.Sprintf("docker exec -it %s sh", name),
+		fmt.Sprintf("docker logs -f %s", name),
+	)
+}
+
+// keepForDebugging renames the container so it stands out and prints how to get at it
+func keepForDebugging(t testing.TB, c *Container) {
+	keptName := c.ContainerName() + "-failed"
+	if err := API().ContainerRename(context.Background(), c.ID(), keptName); err == nil {
+		c.SetName(keptName)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Keeping container %s for failed test %s. Connect with:\n", c.ContainerName(), t.Name())
+	for _, command := range c.DebugCommands() {
+		fmt.Fprintf(&sb, "  %s\n", command)
+	}
+	fmt.Fprintf(&sb, "Remove it with:\n  docker rm -f %s\n", c.ContainerName())
+	fmt.Fprintf(&sb, "Remove all kept containers with:\n  docker rm -f $(docker ps -aq --filter label=%s --filter name=-failed)", LabelKey)
+	t.Log(sb.String())
+}
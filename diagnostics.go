@@ -0,0 +1,120 @@
+package cntest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cybernostics/cntest/wait"
+)
+
+// ReadinessLogTailLines is the number of log lines captured when a container never becomes ready
+var ReadinessLogTailLines = 50
+
+// ReadinessError explains why a container never became ready
+type ReadinessError struct {
+	// Name of the container
+	Name string
+	// Err is the reason the wait ended. Either a wait.TimeoutError or the error the probe failed with
+	Err error
+	// LastProbeErr is the last error returned by the readiness probe, if any
+	LastProbeErr error
+	// Attempts is the number of times the readiness probe was called
+	Attempts int
+	// Elapsed is how long we waited for the container
+	Elapsed time.Duration
+	// State is the docker container status eg running, exited
+	State string
+	// ExitCode of the container if it has exited
+	ExitCode int
+	// OOMKilled is true if the container ran out of memory
+	OOMKilled bool
+	// HealthLog holds the output of the docker healthchecks if the image has one
+	HealthLog []string
+	// LogTail holds the last few lines of the container logs
+	LogTail string
+}
+
+func (e *ReadinessError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "container %s not ready after %v (%d attempts): %v",
+		e.Name, e.Elapsed.Round(time.Millisecond), e.Attempts, e.Err)
+	if e.LastProbeErr != nil && e.LastProbeErr != e.Err {
+		fmt.Fprintf(&sb, "\nlast probe error: %v", e.LastProbeErr)
+	}
+	fmt.Fprintf(&sb, "\nstate: %s exit code: %d OOMKilled: %t", e.State, e.ExitCode, e.OOMKilled)
+	for _, health := range e.HealthLog {
+		fmt.Fprintf(&sb, "\nhealthcheck: %s", strings.TrimSpace(health))
+	}
+	if len(e.LogTail) > 0 {
+		fmt.Fprintf(&sb, "\nlast logs:\n%s", e.LogTail)
+	}
+	return sb.String()
+}
+
+// Unwrap returns the reason the wait ended
+func (e *ReadinessError) Unwrap() error {
+	return e.Err
+}
+
+// IsTimeout is true if the container simply ran out of time to get ready
+func (e *ReadinessError) IsTimeout() bool {
+	return errors.Is(e.Err, wait.ErrTimeout)
+}
+
+// awaitReady waits for the probe and turns any failure into a ReadinessError
+// Probe errors stop the wait unless they are marked with wait.Retryable
+func (c *Container) awaitReady(timeoutSeconds int, probe func() (bool, error)) (bool, error) {
+	started := time.Now()
+	attempts := 0
+	var lastProbeErr error
+	err := wait.Until(context.Background(), func(context.Context) (bool, error) {
+		attempts++
+		ready, err := probe()
+		if err != nil {
+			lastProbeErr = err
+		}
+		return ready, err
+	}, wait.Timeout(time.Duration(timeoutSeconds)*time.Second), wait.FailFast())
+	if err != nil {
+		return false, c.readinessError(err, unwrapRetryable(lastProbeErr), attempts, time.Since(started))
+	}
+	return true, nil
+}
+
+// unwrapRetryable strips the wait.Retryable marker for reporting
+func unwrapRetryable(err error) error {
+	if wait.IsRetryable(err) {
+		return errors.Unwrap(err)
+	}
+	return err
+}
+
+// readinessError gathers the container state and logs to explain a failed wait
+func (c *Container) readinessError(err error, lastProbeErr error, attempts int, elapsed time.Duration) *ReadinessError {
+	readyErr := &ReadinessError{
+		Name:         c.ContainerName(),
+		Err:          err,
+		LastProbeErr: lastProbeErr,
+		Attempts:     attempts,
+		Elapsed:      elapsed,
+	}
+	if id, err := c.id(); err != nil {
+		readyErr.State = c.State().String()
+	} else if inspect, err := API().ContainerInspect(context.Background(), id); err == nil && inspect.State != nil {
+		readyErr.State = inspect.State.Status
+		readyErr.ExitCode = inspect.State.ExitCode
+		readyErr.OOMKilled = inspect.State.OOMKilled
+		if inspect.State.Health != nil {
+			for _, result := range inspect.State.Health.Log {
+				readyErr.HealthLog = append(readyErr.HealthLog, result.Output)
+			}
+		}
+	}
+	if tail, err := c.LogTail(ReadinessLogTailLines); err == nil {
+		readyErr.LogTail = tail
+	}
+	return readyErr
+}
@@ -44,3 +44,104 @@ func TestMysqlRunWith(t *testing.T) {
 
 	})
 }
+
+func TestMysqlTestDatabases(t *testing.T) {
+	cntest.PullImage("mysql", "8", cntest.FromDockerHub)
+	my, err := mysql.New(mysql.WithInitDB("../fixtures/testschema"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, my.Container, func(t *testing.T) {
+		golden, err := sqlx.Open(my.Driver(), my.DSN())
+		then.AssertThat(t, err, is.Nil())
+		defer golden.Close()
+		_, err = golden.Exec("create table codes (code varchar(10), lower_code varchar(10) generated always as (lower(code)) stored)")
+		then.AssertThat(t, err, is.Nil())
+		_, err = golden.Exec("insert into codes (code) values ('A007')")
+		then.AssertThat(t, err, is.Nil())
+
+		// the group waits for the parallel tests before the container is stopped
+		t.Run("group", func(t *testing.T) {
+			t.Run("generated columns", func(t *testing.T) {
+				t.Parallel()
+				dbx, err := sqlx.Open(my.Driver(), my.NewTestDatabase(t, mysql.CopyRows("codes")))
+				then.AssertThat(t, err, is.Nil())
+				defer dbx.Close()
+
+				var lowerCode string
+				err = dbx.Get(&lowerCode, "select lower_code from codes")
+				then.AssertThat(t, err, is.Nil())
+				then.AssertThat(t, lowerCode, is.EqualTo("a007"))
+			})
+			t.Run("empty", func(t *testing.T) {
+				t.Parallel()
+				dbx, err := sqlx.Open(my.Driver(), my.NewTestDatabase(t))
+				then.AssertThat(t, err, is.Nil())
+				defer dbx.Close()
+
+				var count int
+				err = dbx.Get(&count, "select count(*) from agents")
+				then.AssertThat(t, err, is.Nil())
+				then.AssertThat(t, count, is.EqualTo(0))
+			})
+			t.Run("seeded", func(t *testing.T) {
+				t.Parallel()
+				dbx, err := sqlx.Open(my.Driver(), my.NewTestDatabase(t, mysql.CopyRows("agents")))
+				then.AssertThat(t, err, is.Nil())
+				defer dbx.Close()
+
+				var count int
+				err = dbx.Get(&count, "select count(*) from agents")
+				then.AssertThat(t, err, is.Nil())
+				then.AssertThat(t, count, is.GreaterThan(0))
+			})
+		})
+	})
+}
+
+func TestMysqlServerSettings(t *testing.T) {
+	cntest.PullImage("mysql", "8", cntest.FromDockerHub)
+	my, err := mysql.New(
+		mysql.WithInitDB("../fixtures/testschema"),
+		mysql.WithSQLMode("STRICT_TRANS_TABLES"),
+		mysql.WithCharset("utf8mb4", "utf8mb4_unicode_ci"),
+		mysql.WithTimeZone("+00:00"),
+		mysql.WithFastMode(),
+	)
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, my.Container, func(t *testing.T) {
+		dbx, err := sqlx.Open(my.Driver(), my.DSN())
+		then.AssertThat(t, err, is.Nil())
+		defer dbx.Close()
+
+		var sqlMode, collation, timeZone string
+		err = dbx.QueryRow("select @@global.sql_mode, @@collation_server, @@global.time_zone").Scan(&sqlMode, &collation, &timeZone)
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, sqlMode, is.EqualTo("STRICT_TRANS_TABLES"))
+		then.AssertThat(t, collation, is.EqualTo("utf8mb4_unicode_ci"))
+		then.AssertThat(t, timeZone, is.EqualTo("+00:00"))
+	})
+}
+
+func TestMysqlFastModeMatrix(t *testing.T) {
+	fast := func(opts ...cntest.Option) (*mysql.Instance, error) {
+		return mysql.New(append(opts, mysql.WithInitDB("../fixtures/testschema"), mysql.WithFastMode())...)
+	}
+	cntest.Matrix(t, mysql.Variants(), fast, func(t *testing.T, my *mysql.Instance) {
+		dbx, err := sqlx.Open(my.Driver(), my.DSN())
+		then.AssertThat(t, err, is.Nil())
+		defer dbx.Close()
+
+		var dataDir string
+		var flushAtCommit, logBin int
+		err = dbx.QueryRow("select @@datadir, @@innodb_flush_log_at_trx_commit, @@log_bin").Scan(&dataDir, &flushAtCommit, &logBin)
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, dataDir, is.EqualTo("/cntest/mysql-tmpfs/"))
+		then.AssertThat(t, flushAtCommit, is.EqualTo(0))
+		then.AssertThat(t, logBin, is.EqualTo(0))
+
+		var count int
+		then.AssertThat(t, dbx.Get(&count, "select count(*) from agents"), is.Nil())
+		then.AssertThat(t, count, is.GreaterThan(0))
+	})
+}
@@ -1,6 +1,7 @@
 package examples
 
 import (
+	"context"
 	"fmt"
 	"github.com/corbym/gocrest/then"
 	"testing"
@@ -15,9 +16,8 @@ import (
 func TestPostgresRunWith(t *testing.T) {
 	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
 	cnt := postgres.Container(cntest.PropertyMap{"initdb_path": "../fixtures/testschema"})
-	cnt.RemoveAfterTest=false
-	cnt.StopAfterTest=false
-	
+	// run with CNTEST_KEEP_ON_FAILURE=1 to keep the container around if the test fails
+
 	cntest.ExecuteWithRunningContainer(t, cnt, func(t *testing.T) {
 
 		// Open up our database connection.
@@ -40,3 +40,157 @@ func TestPostgresRunWith(t *testing.T) {
 		}
 	})
 }
+
+func TestPostgresNewReportsBadConfig(t *testing.T) {
+	pg, err := postgres.New(postgres.WithInitDB("../fixtures/missing"), cntest.WithImage(""))
+	then.AssertThat(t, pg, is.NilPtr[postgres.Instance]())
+	then.AssertThat(t, err.Error(), is.StringContaining("/docker-entrypoint-initdb.d does not exist"))
+	then.AssertThat(t, err.Error(), is.StringContaining("image must not be empty"))
+}
+
+func TestPostgresNew(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	pg, err := postgres.New(postgres.WithDatabase("agents"), postgres.WithInitDB("../fixtures/testschema"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, pg.Container, func(t *testing.T) {
+		db, err := pg.DBConnect(pg.MaxStartTimeSeconds)
+		then.AssertThat(t, err, is.Nil())
+		defer db.Close()
+
+		var name string
+		err = db.QueryRow("select current_database()").Scan(&name)
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, name, is.EqualTo("agents"))
+	})
+}
+
+func TestPostgresTestDatabases(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	pg, err := postgres.New(postgres.WithInitDB("../fixtures/testschema"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, pg.Container, func(t *testing.T) {
+		// the group waits for the parallel tests before the container is stopped
+		t.Run("group", func(t *testing.T) {
+			for _, name := range []string{"first", "second", "third"} {
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					dbx, err := sqlx.Open(pg.Driver(), pg.NewTestDatabase(t))
+					then.AssertThat(t, err, is.Nil())
+					defer dbx.Close()
+
+					// each test gets its own copy of the schema and data to change
+					_, err = dbx.Exec("delete from agents")
+					then.AssertThat(t, err, is.Nil())
+					var count int
+					err = dbx.Get(&count, "select count(*) from agents")
+					then.AssertThat(t, err, is.Nil())
+					then.AssertThat(t, count, is.EqualTo(0))
+				})
+			}
+		})
+	})
+}
+
+func TestPostgresTestTx(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	pg, err := postgres.New(postgres.WithInitDB("../fixtures/testschema"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, pg.Container, func(t *testing.T) {
+		countAgents := func(tx *cntest.TestTx) int {
+			var count int
+			err := tx.Get(&count, "select count(*) from agents")
+			then.AssertThat(t, err, is.Nil())
+			return count
+		}
+
+		t.Run("deletes in a transaction", func(t *testing.T) {
+			tx := pg.BeginTestTx(t)
+			_, err := tx.Exec("delete from agents where agent_code = 'A007'")
+			then.AssertThat(t, err, is.Nil())
+
+			// code under test which rolls back its own transaction
+			savepoint, err := tx.Savepoint(context.Background())
+			then.AssertThat(t, err, is.Nil())
+			_, err = tx.Exec("delete from agents")
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, savepoint.Rollback(), is.Nil())
+			then.AssertThat(t, countAgents(tx), is.GreaterThan(0))
+		})
+		t.Run("sees the original data", func(t *testing.T) {
+			tx := pg.BeginTestTx(t)
+			var count int
+			err := tx.Get(&count, "select count(*) from agents where agent_code = 'A007'")
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, count, is.EqualTo(1))
+		})
+	})
+}
+
+func TestPostgresSnapshot(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	newPostgres := func() *postgres.Instance {
+		pg, err := postgres.New(postgres.WithInitDB("../fixtures/testschema"), postgres.WithSnapshot(t.Name()))
+		then.AssertThat(t, err, is.Nil())
+		return pg
+	}
+	agentsAreLoaded := func(t *testing.T, pg *postgres.Instance) {
+		dbx, err := pg.OpenSQLX(context.Background())
+		then.AssertThat(t, err, is.Nil())
+		defer dbx.Close()
+		var count int
+		then.AssertThat(t, dbx.Get(&count, "select count(*) from agents"), is.Nil())
+		then.AssertThat(t, count, is.GreaterThan(0))
+	}
+
+	// the first run initialises the db and saves the snapshot
+	first := newPostgres()
+	cntest.ExecuteWithRunningContainer(t, first.Container, func(t *testing.T) {
+		agentsAreLoaded(t, first)
+	})
+
+	second := newPostgres()
+	cntest.ExecuteWithRunningContainer(t, second.Container, func(t *testing.T) {
+		then.AssertThat(t, second.Config.Image, is.StringContaining(cntest.SnapshotRepo))
+		logs, err := second.Logs()
+		then.AssertThat(t, err, is.Nil())
+		then.AssertThat(t, logs, is.StringContaining("Skipping initialization"))
+		agentsAreLoaded(t, second)
+	})
+}
+
+func TestPostgresFastMode(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	pg, err := postgres.New(postgres.WithInitDB("../fixtures/testschema"), postgres.WithFastMode(), postgres.WithSetting("max_connections", "50"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, pg.Container, func(t *testing.T) {
+		dbx, err := pg.OpenSQLX(context.Background())
+		then.AssertThat(t, err, is.Nil())
+		defer dbx.Close()
+
+		var fsync, maxConnections string
+		then.AssertThat(t, dbx.Get(&fsync, "show fsync"), is.Nil())
+		then.AssertThat(t, fsync, is.EqualTo("off"))
+		then.AssertThat(t, dbx.Get(&maxConnections, "show max_connections"), is.Nil())
+		then.AssertThat(t, maxConnections, is.EqualTo("50"))
+	})
+}
+
+func TestPostgresConfigFile(t *testing.T) {
+	cntest.PullImage("postgres", "13", cntest.FromDockerHub)
+	pg, err := postgres.New(postgres.WithConfigFile("../postgres/testdata/postgresql.conf"))
+	then.AssertThat(t, err, is.Nil())
+
+	cntest.ExecuteWithRunningContainer(t, pg.Container, func(t *testing.T) {
+		dbx, err := pg.OpenSQLX(context.Background())
+		then.AssertThat(t, err, is.Nil())
+		defer dbx.Close()
+
+		var maxConnections string
+		then.AssertThat(t, dbx.Get(&maxConnections, "show max_connections"), is.Nil())
+		then.AssertThat(t, maxConnections, is.EqualTo("50"))
+	})
+}
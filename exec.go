@@ -1,6 +1,8 @@
 package cntest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -9,17 +11,54 @@ import (
 // ContainerTestFn implement your DB tests using this signature
 type ContainerTestFn func(t *testing.T)
 
+// ContainerTestCtxFn is a test function which also gets a context that is
+// cancelled if the container dies while the test is running
+type ContainerTestCtxFn func(ctx context.Context, t *testing.T)
+
 // ExecuteWithRunningContainer wraps a test function by creating a db
 func ExecuteWithRunningContainer(t *testing.T, c *Container, userTestFn ContainerTestFn) {
+	ExecuteWithRunningContainerCtx(t, c, func(_ context.Context, t *testing.T) {
+		userTestFn(t)
+	})
+}
+
+// ExecuteWithRunningContainerCtx wraps a test function by creating a container.
+// The test fails if the container dies or is OOM killed while the test is running.
+// In keep-on-failure mode (see KeepOnFailure) the container is left running if the test fails.
+func ExecuteWithRunningContainerCtx(t *testing.T, c *Container, userTestFn ContainerTestCtxFn) {
 	isOk := false
+	var readyErr error
+	kept := false
+	keep := func() bool {
+		if kept {
+			return true
+		}
+		if !IsKeepOnFailure() || (isOk && !t.Failed()) {
+			return false
+		}
+		kept = true
+		keepForDebugging(t, c)
+		return true
+	}
+	if c.Config.Labels == nil {
+		c.Config.Labels = map[string]string{}
+	}
+	c.Config.Labels[TestLabelKey] = t.Name()
 	containerID, err := c.Start()
 	defer func() {
 		if !isOk {
-			t.Errorf("Failed to run container.")
+			if readyErr != nil {
+				t.Errorf("Failed to run container: %v", readyErr)
+			} else {
+				t.Errorf("Failed to run container.")
+			}
 			logsStr, err := c.Logs()
-			if err != nil {
+			if err == nil {
 				fmt.Printf("Logs were: %s\n", logsStr)
 			}
+			if keep() {
+				return
+			}
 			if c.StopAfterTest {
 				_, _ = c.Stop(0)
 			}
@@ -31,6 +70,9 @@ func ExecuteWithRunningContainer(t *testing.T, c *Container, userTestFn Containe
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Printf("Panic within ExecuteWithRunningContainer. Error was: %v", err)
+			if keep() {
+				return
+			}
 			if c.StopAfterTest {
 				_, _ = c.Stop(0)
 			}
@@ -45,41 +87,50 @@ func ExecuteWithRunningContainer(t *testing.T, c *Container, userTestFn Containe
 	}
 	fmt.Printf("Started %s - Awaiting ready\n", containerID)
 	if ok, err := c.AwaitIsReady(); !ok {
+		readyErr = err
+		captureArtifacts(t, c)
 		if c.StopAfterTest {
 			defer func() {
-				_, _ = c.Stop(10)
+				if !keep() {
+					_, _ = c.Stop(10)
+				}
 			}()
 		}
 		if c.RemoveAfterTest {
 			defer func() {
-				_ = c.Remove()
+				if !keep() {
+					_ = c.Remove()
+				}
 			}()
 		}
-		if err != nil {
-			t.Errorf("Couldn't start container: %s\n Error was %v", c.Instance.ID, err)
-		}
 	}
 	if c.IsStopAfterTest() {
 		defer func() {
+			if keep() {
+				return
+			}
 			_, err := c.Stop(10)
 			if err != nil {
-				if !strings.Contains(err.Error(),"No such container"){
-					t.Errorf("Couldn't stop container: %s\n Error was %v", c.Instance.ID, err)
+				if !errors.Is(err, ErrRemoved) && !strings.Contains(err.Error(), "No such container") {
+					t.Errorf("Couldn't stop container: %s\n Error was %v", c.ID(), err)
 				}
 			}
 
 			if c.IsRemoveAfterTest() {
 				err = c.Remove()
 				if err != nil {
-					if !strings.Contains(err.Error(),"No such container"){
-						t.Errorf("Couldn't remove container: %s\n Error was %v", c.Instance.ID, err)
-					}
+					if !errors.Is(err, ErrRemoved) && !strings.Contains(err.Error(), "No such container") {
+						t.Errorf("Couldn't remove container: %s\n Error was %v", c.ID(), err)
 					}
+				}
 			}
 		}()
 	}
 	if ready, err := c.ContainerReady(); err == nil && ready {
-		userTestFn(t)
+		defer CaptureArtifacts(t, c)
+		ctx, stopWatching := WatchForExit(context.Background(), t, c)
+		defer stopWatching()
+		userTestFn(ctx, t)
 		isOk = true
 
 	}
@@ -0,0 +1,212 @@
+package cntest
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// DefaultNetwork is the docker network containers join unless their HostConfig says otherwise
+const DefaultNetwork = "bridge"
+
+// Pause freezes all the processes in the container like docker pause
+// Clients see the container hang rather than refuse connections
+func (c *Container) Pause() error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	if err = API().ContainerPause(context.Background(), id); err != nil {
+		return err
+	}
+	c.changeState(func(from State) State {
+		c.pausedFrom = from
+		return Paused
+	})
+	return nil
+}
+
+// Unpause resumes a paused container in the state it was paused in
+func (c *Container) Unpause() error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	if err = API().ContainerUnpause(context.Background(), id); err != nil {
+		return err
+	}
+	c.changeState(func(from State) State {
+		if from != Paused {
+			return from
+		}
+		return c.pausedFrom
+	})
+	return nil
+}
+
+// Kill sends the signal to the container's main process eg "SIGKILL" or "SIGHUP"
+// An exit caused by SIGKILL, SIGTERM or SIGINT is treated as intentional, so it won't fail
+// a watched test. Other signals don't stop crashes from being reported.
+func (c *Container) Kill(signal string) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	if terminates(signal) {
+		c.expectedExit.Store(true)
+	}
+	if err = API().ContainerKill(context.Background(), id, signal); err != nil {
+		return err
+	}
+	// containers can't outlive SIGKILL. Others leave it to the container, and the
+	// state catches up when docker is next asked about it
+	if isSIGKILL(signal) {
+		c.setState(Exited)
+	}
+	return nil
+}
+
+// terminates reports whether the signal is one sent to stop a container
+// docker accepts signals as names with or without the SIG prefix, or as numbers
+func terminates(signal string) bool {
+	switch signalName(signal) {
+	case "KILL", "TERM", "INT", "9", "15", "2":
+		return true
+	}
+	return false
+}
+
+func isSIGKILL(signal string) bool {
+	name := signalName(signal)
+	return name == "KILL" || name == "9"
+}
+
+// signalName strips the optional SIG prefix docker allows
+func signalName(signal string) string {
+	return strings.TrimPrefix(strings.ToUpper(signal), "SIG")
+}
+
+// Restart stops the container, giving it timeoutSeconds to shut down, then starts it again.
+// The host port mappings are kept so clients can reconnect to the same HostPort.
+func (c *Container) Restart(timeoutSeconds int) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	c.expectedExit.Store(true)
+	err = API().ContainerRestart(context.Background(), id, container.StopOptions{Timeout: &timeoutSeconds})
+	if err != nil {
+		return err
+	}
+	c.restarted()
+	return nil
+}
+
+// Resume starts a stopped or killed container again with the same host port mappings
+func (c *Container) Resume() error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	err = API().ContainerStart(context.Background(), id, container.StartOptions{})
+	if err != nil {
+		return err
+	}
+	c.restarted()
+	return nil
+}
+
+// DisconnectNetwork cuts the container off from the network
+// Use DefaultNetwork for containers on the default bridge network
+func (c *Container) DisconnectNetwork(network string) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	return API().NetworkDisconnect(context.Background(), network, id, true)
+}
+
+// ConnectNetwork connects the container to the network again
+func (c *Container) ConnectNetwork(network string) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	err = API().NetworkConnect(context.Background(), network, id, nil)
+	c.setIPAddress("")
+	return err
+}
+
+// Network returns the network the container was started on
+func (c *Container) Network() string {
+	c.mu.RLock()
+	mode := c.HostConfig.NetworkMode
+	c.mu.RUnlock()
+	if len(mode) > 0 && mode.IsUserDefined() {
+		return string(mode)
+	}
+	return DefaultNetwork
+}
+
+// WhileDown kills the container, runs fn, then starts the container again and waits for it
+// to be ready. The container is restored even if fn fails the test.
+func (c *Container) WhileDown(fn func()) (err error) {
+	if err = c.Kill("SIGKILL"); err != nil {
+		return err
+	}
+	if _, err = c.AwaitExit(c.MaxStartTimeSeconds); err != nil {
+		return err
+	}
+	defer func() {
+		err = c.restore(c.Resume)
+	}()
+	fn()
+	return nil
+}
+
+// WhilePaused pauses the container, runs fn, then unpauses it
+func (c *Container) WhilePaused(fn func()) (err error) {
+	if err = c.Pause(); err != nil {
+		return err
+	}
+	defer func() {
+		err = c.Unpause()
+	}()
+	fn()
+	return nil
+}
+
+// WhileDisconnected disconnects the container from its network, runs fn,
+// then reconnects it and waits for it to be ready again
+func (c *Container) WhileDisconnected(fn func()) (err error) {
+	network := c.Network()
+	if err = c.DisconnectNetwork(network); err != nil {
+		return err
+	}
+	defer func() {
+		err = c.restore(func() error {
+			return c.ConnectNetwork(network)
+		})
+	}()
+	fn()
+	return nil
+}
+
+// restore brings the container back and waits for it to be ready
+func (c *Container) restore(bringBack func() error) error {
+	if err := bringBack(); err != nil {
+		return err
+	}
+	_, err := c.AwaitIsReady()
+	return err
+}
+
+// restarted records that the container is running again so later exits are unexpected
+func (c *Container) restarted() {
+	c.setState(Running)
+	c.setIPAddress("")
+	c.lastStarted.Store(time.Now().UnixNano())
+	c.expectedExit.Store(false)
+}
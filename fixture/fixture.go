@@ -0,0 +1,191 @@
+// Package fixture loads test data into a db container from YAML, JSON and CSV files.
+//
+// YAML and JSON files map table names to rows. The rows are either a list or
+// a map of named rows so other fixtures can refer to them:
+//
+//	agents:
+//	  alex:
+//	    agent_code: A003
+//	    agent_name: Alex
+//	customers:
+//	  - cust_name: "{{ randomName }}"
+//	    agent_code: '{{ ref "agents.alex.agent_code" }}'
+//	    created: "{{ now }}"
+//
+// CSV files hold the rows of the table they are named after, with the column names
+// in the first line. A \N field is NULL. Rows in lists and CSV files are named by their index.
+package fixture
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// csvNull is the CSV field value for NULL
+const csvNull = `\N`
+
+// Row is a row of fixture data
+type Row struct {
+	// Name is used to refer to the row from other fixtures
+	Name string
+	// Values are the column values. Strings containing {{ }} are templates
+	Values map[string]any
+}
+
+// Fixtures are the rows to load into each table
+type Fixtures struct {
+	tables map[string][]Row
+}
+
+// Read reads the .yml, .yaml, .json and .csv fixture files in the root of fsys
+// Rows for the same table in several files are combined in file name order
+func Read(fsys fs.FS) (*Fixtures, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixtures{tables: map[string][]Row{}}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err = f.readFile(fsys, entry.Name()); err != nil {
+			return nil, fmt.Errorf("unable to read fixture %s: %w", entry.Name(), err)
+		}
+	}
+	return f, nil
+}
+
+// ReadDir reads the fixture files in dir
+func ReadDir(dir string) (*Fixtures, error) {
+	return Read(os.DirFS(dir))
+}
+
+// Tables returns the names of the tables with fixture rows
+func (f *Fixtures) Tables() []string {
+	var tables []string
+	for table := range f.tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Rows returns the fixture rows for a table before the templates are run
+func (f *Fixtures) Rows(table string) []Row {
+	return f.tables[table]
+}
+
+func (f *Fixtures) readFile(fsys fs.FS, name string) error {
+	ext := strings.ToLower(path.Ext(name))
+	switch ext {
+	case ".yml", ".yaml", ".json", ".csv":
+	default:
+		return nil
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if ext == ".csv" {
+		rows, err := csvRows(content)
+		if err != nil {
+			return err
+		}
+		f.add(strings.TrimSuffix(name, path.Ext(name)), rows)
+		return nil
+	}
+
+	var tables map[string]any
+	if ext == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&tables)
+	} else {
+		err = yaml.Unmarshal(content, &tables)
+	}
+	if err != nil {
+		return err
+	}
+	for table, data := range tables {
+		rows, err := tableRows(table, data)
+		if err != nil {
+			return err
+		}
+		f.add(table, rows)
+	}
+	return nil
+}
+
+// add appends rows to a table, naming unnamed rows by their index in the table
+func (f *Fixtures) add(table string, rows []Row) {
+	for _, row := range rows {
+		if len(row.Name) == 0 {
+			row.Name = strconv.Itoa(len(f.tables[table]))
+		}
+		f.tables[table] = append(f.tables[table], row)
+	}
+}
+
+// tableRows reads a list or map of named rows
+func tableRows(table string, data any) ([]Row, error) {
+	var rows []Row
+	switch data := data.(type) {
+	case []any:
+		for i, item := range data {
+			values, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("row %d of %s is not a map of column values", i, table)
+			}
+			rows = append(rows, Row{Values: values})
+		}
+	case map[string]any:
+		var names []string
+		for name := range data {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			values, ok := data[name].(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("row %s of %s is not a map of column values", name, table)
+			}
+			rows = append(rows, Row{Name: name, Values: values})
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("%s should be a list or map of rows", table)
+	}
+	return rows, nil
+}
+
+func csvRows(content []byte) ([]Row, error) {
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	header := records[0]
+	var rows []Row
+	for _, record := range records[1:] {
+		values := map[string]any{}
+		for i, column := range header {
+			if record[i] == csvNull {
+				values[column] = nil
+			} else {
+				values[column] = record[i]
+			}
+		}
+		rows = append(rows, Row{Values: values})
+	}
+	return rows, nil
+}
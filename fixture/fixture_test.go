@@ -0,0 +1,65 @@
+package fixture_test
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/fixture"
+)
+
+func TestReadCombinesFileFormats(t *testing.T) {
+	f, err := fixture.ReadDir("../fixtures/data")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, f.Tables(), is.EqualTo([]string{"agents"}))
+
+	rows := f.Rows("agents")
+	then.AssertThat(t, len(rows), is.EqualTo(3))
+	// files are read in name order and unnamed rows are named by index
+	then.AssertThat(t, rows[0].Name, is.EqualTo("0"))
+	then.AssertThat(t, rows[0].Values["country"], is.EqualTo[any](nil))
+	then.AssertThat(t, rows[1].Name, is.EqualTo("alex"))
+	then.AssertThat(t, rows[1].Values["commission"], is.EqualTo[any](0.13))
+}
+
+func TestValuesRunTemplates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.json": {Data: []byte(`{
+			"orders": [{"agent_code": "{{ ref \"agents.alex.agent_code\" }}", "agent": "{{ ref \"agents.alex.agent_name\" }}", "ord_num": 200100}],
+			"agents": {"alex": {"agent_code": "A003", "agent_name": "{{ randomName }}", "created": "{{ now }}"}}
+		}`)},
+	}
+	f, err := fixture.Read(fsys)
+	then.AssertThat(t, err, is.Nil())
+
+	orders, err := f.Values("orders")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, orders[0]["agent_code"], is.EqualTo[any]("A003"))
+	then.AssertThat(t, orders[0]["agent"], is.Not(is.EqualTo[any]("{{ randomName }}")))
+	then.AssertThat(t, string(orders[0]["ord_num"].(json.Number)), is.EqualTo("200100"))
+
+	agents, err := f.Values("agents")
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, agents[0]["created"].(string), is.MatchForPattern(`^\d{4}-\d\d-\d\d \d\d:\d\d:\d\d$`))
+}
+
+func TestValuesReportBadReferences(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data.yaml": {Data: []byte(`
+orders:
+  - agent_code: '{{ ref "agents.bob.agent_code" }}'
+loop:
+  a:
+    value: '{{ ref "loop.a.value" }}'
+`)},
+	}
+	f, err := fixture.Read(fsys)
+	then.AssertThat(t, err, is.Nil())
+
+	_, err = f.Values("orders")
+	then.AssertThat(t, err.Error(), is.StringContaining("there is no fixture agents.bob"))
+	_, err = f.Values("loop")
+	then.AssertThat(t, err.Error(), is.StringContaining("fixture loop.a refers to itself"))
+}
@@ -0,0 +1,314 @@
+package fixture
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/random"
+	"github.com/jmoiron/sqlx"
+)
+
+// timeFormat is how {{ now }} is written. Both mysql and postgres accept it for timestamp columns
+const timeFormat = "2006-01-02 15:04:05"
+
+// foreignKeys list the (table, referenced table) pairs in the current schema for each driver
+var foreignKeys = map[string]string{
+	"postgres": `SELECT tc.table_name, ccu.table_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.constraint_column_usage ccu
+			ON tc.constraint_name = ccu.constraint_name AND tc.constraint_schema = ccu.constraint_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema()`,
+	"mysql": `SELECT table_name, referenced_table_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL`,
+}
+
+// Load inserts the fixture rows in one transaction, parent tables first.
+// driver is the database/sql driver name eg "postgres"
+func (f *Fixtures) Load(ctx context.Context, db *sql.DB, driver string) error {
+	return f.inTx(ctx, db, driver, func(tx *sqlx.Tx, tables []string) error {
+		return f.insert(ctx, tx, driver, tables)
+	})
+}
+
+// Reset deletes all the rows in the fixture tables, child tables first,
+// and loads the fixtures again in one transaction
+func (f *Fixtures) Reset(ctx context.Context, db *sql.DB, driver string) error {
+	return f.inTx(ctx, db, driver, func(tx *sqlx.Tx, tables []string) error {
+		for i := len(tables) - 1; i >= 0; i-- {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+quoteName(driver, tables[i])); err != nil {
+				return fmt.Errorf("unable to clear %s: %w", tables[i], err)
+			}
+		}
+		return f.insert(ctx, tx, driver, tables)
+	})
+}
+
+// LoadContainer loads the fixtures over the container's DBConnect connection
+func (f *Fixtures) LoadContainer(ctx context.Context, c *cntest.Container) error {
+	return withContainerDB(c, func(db *sql.DB) error {
+		return f.Load(ctx, db, c.Props[cntest.DriverProp])
+	})
+}
+
+// ResetContainer resets the fixture tables over the container's DBConnect connection
+func (f *Fixtures) ResetContainer(ctx context.Context, c *cntest.Container) error {
+	return withContainerDB(c, func(db *sql.DB) error {
+		return f.Reset(ctx, db, c.Props[cntest.DriverProp])
+	})
+}
+
+// With is a container option which loads the fixtures once the container is ready
+// Add it after any migrations so the tables exist
+func With(f *Fixtures) cntest.Option {
+	return func(c *cntest.Container) error {
+		c.Hooks.PostReady = append(c.Hooks.PostReady, f.LoadContainer)
+		return nil
+	}
+}
+
+// Values returns the rows for a table with the templates run, as they would be inserted
+func (f *Fixtures) Values(table string) ([]map[string]any, error) {
+	r := newResolver(f)
+	var values []map[string]any
+	for _, row := range f.tables[table] {
+		rowValues, err := r.row(table, row.Name)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, rowValues)
+	}
+	return values, nil
+}
+
+func withContainerDB(c *cntest.Container, fn func(db *sql.DB) error) error {
+	db, err := c.DBConnect(c.MaxStartTimeSeconds)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+// inTx runs fn in a transaction with the fixture tables in foreign key order
+func (f *Fixtures) inTx(ctx context.Context, db *sql.DB, driver string, fn func(tx *sqlx.Tx, tables []string) error) error {
+	tx, err := sqlx.NewDb(db, driver).BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tables, err := f.order(ctx, tx, driver)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx, tables); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (f *Fixtures) insert(ctx context.Context, tx *sqlx.Tx, driver string, tables []string) error {
+	r := newResolver(f)
+	for _, table := range tables {
+		for _, row := range f.tables[table] {
+			values, err := r.row(table, row.Name)
+			if err != nil {
+				return err
+			}
+			var columns []string
+			for column := range values {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+			names := make([]string, len(columns))
+			args := make([]any, len(columns))
+			for i, column := range columns {
+				names[i] = quoteName(driver, column)
+				args[i] = values[column]
+			}
+			statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteName(driver, table),
+				strings.Join(names, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+			if _, err = tx.ExecContext(ctx, tx.Rebind(statement), args...); err != nil {
+				return fmt.Errorf("unable to insert fixture %s.%s: %w", table, row.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// order sorts the fixture tables so referenced tables come before the tables referring to them
+func (f *Fixtures) order(ctx context.Context, tx *sqlx.Tx, driver string) ([]string, error) {
+	query, ok := foreignKeys[driver]
+	if !ok {
+		return nil, fmt.Errorf("fixtures don't support the %s driver", driver)
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	parents := map[string]map[string]bool{}
+	for rows.Next() {
+		var table, parent string
+		if err = rows.Scan(&table, &parent); err != nil {
+			return nil, err
+		}
+		_, isFixture := f.tables[parent]
+		if table == parent || !isFixture {
+			continue
+		}
+		if parents[table] == nil {
+			parents[table] = map[string]bool{}
+		}
+		parents[table][parent] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sortTables(f.Tables(), parents)
+}
+
+// sortTables puts parents before their children, keeping name order otherwise
+func sortTables(tables []string, parents map[string]map[string]bool) ([]string, error) {
+	var sorted []string
+	done := map[string]bool{}
+	for len(sorted) < len(tables) {
+		progress := false
+		for _, table := range tables {
+			if done[table] {
+				continue
+			}
+			ready := true
+			for parent := range parents[table] {
+				if !done[parent] {
+					ready = false
+				}
+			}
+			if ready {
+				sorted = append(sorted, table)
+				done[table] = true
+				progress = true
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, table := range tables {
+				if !done[table] {
+					cycle = append(cycle, table)
+				}
+			}
+			return nil, fmt.Errorf("fixture tables have a foreign key cycle: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// resolver runs the value templates, remembering the results so references see the same values
+type resolver struct {
+	f         *Fixtures
+	now       string
+	values    map[string]map[string]any
+	resolving map[string]bool
+}
+
+func newResolver(f *Fixtures) *resolver {
+	return &resolver{
+		f:         f,
+		now:       time.Now().UTC().Format(timeFormat),
+		values:    map[string]map[string]any{},
+		resolving: map[string]bool{},
+	}
+}
+
+// row returns the values of a row with its templates run
+func (r *resolver) row(table string, name string) (map[string]any, error) {
+	key := table + "." + name
+	if values, ok := r.values[key]; ok {
+		return values, nil
+	}
+	if r.resolving[key] {
+		return nil, fmt.Errorf("fixture %s refers to itself", key)
+	}
+	r.resolving[key] = true
+	defer delete(r.resolving, key)
+
+	var found *Row
+	for i, row := range r.f.tables[table] {
+		if row.Name == name {
+			found = &r.f.tables[table][i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("there is no fixture %s", key)
+	}
+	values := map[string]any{}
+	for column, value := range found.Values {
+		text, ok := value.(string)
+		if !ok || !strings.Contains(text, "{{") {
+			values[column] = value
+			continue
+		}
+		rendered, err := r.render(text)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s.%s: %w", key, column, err)
+		}
+		values[column] = rendered
+	}
+	r.values[key] = values
+	return values, nil
+}
+
+// render runs a value template
+func (r *resolver) render(text string) (string, error) {
+	funcs := template.FuncMap{
+		"randomName": random.Name,
+		"now": func() string {
+			return r.now
+		},
+		// ref returns a column of another fixture as "table.row.column"
+		"ref": func(path string) (any, error) {
+			dot := strings.LastIndex(path, ".")
+			rowDot := strings.LastIndex(path[:max(dot, 0)], ".")
+			if dot < 0 || rowDot < 0 {
+				return nil, fmt.Errorf("ref %q should be table.row.column", path)
+			}
+			values, err := r.row(path[:rowDot], path[rowDot+1:dot])
+			if err != nil {
+				return nil, err
+			}
+			value, ok := values[path[dot+1:]]
+			if !ok {
+				return nil, fmt.Errorf("fixture %s has no column %s", path[:dot], path[dot+1:])
+			}
+			return value, nil
+		},
+	}
+	tmpl, err := template.New("value").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err = tmpl.Execute(&out, nil); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// quoteName quotes a table or column name, including schema qualified table names
+func quoteName(driver string, name string) string {
+	quote := `"`
+	if driver == "mysql" {
+		quote = "`"
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
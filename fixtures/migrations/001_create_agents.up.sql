@@ -0,0 +1,9 @@
+CREATE TABLE agents (
+  agent_code varchar(6) NOT NULL,
+  agent_name varchar(40) DEFAULT NULL,
+  working_area varchar(35) DEFAULT NULL,
+  commission decimal(10,2) DEFAULT NULL,
+  phone_no varchar(15) DEFAULT NULL,
+  country varchar(25) DEFAULT NULL,
+  PRIMARY KEY (agent_code)
+);
@@ -0,0 +1,4 @@
+INSERT INTO agents (agent_code, agent_name, working_area, commission, phone_no, country) VALUES
+('A007', 'Ramasundar', 'Bangalore', 0.15, '077-25814763', 'India');
+INSERT INTO agents (agent_code, agent_name, working_area, commission, phone_no, country) VALUES
+('A003', 'Alex', 'London', 0.13, '075-12458969', 'UK');
@@ -0,0 +1,38 @@
+package cntest
+
+import (
+	"context"
+	"fmt"
+)
+
+// HookFn runs at a point in the container lifecycle
+// Returning an error from a startup hook aborts the startup
+type HookFn func(ctx context.Context, c *Container) error
+
+// Hooks are the fns run at each point in the container lifecycle.
+// Each list runs in order and stops at the first error.
+// Modules add their own hooks, so append to these rather than replacing them.
+type Hooks struct {
+	// PreCreate runs before the container is created. eg to tweak the config or build files to mount
+	PreCreate []HookFn
+	// PostCreate runs once the container exists but before it is started. eg to copy files in
+	PostCreate []HookFn
+	// PostStart runs straight after the container starts, before it is ready
+	PostStart []HookFn
+	// PostReady runs once per Start when ContainerReady first passes. eg to run migrations or seed data
+	PostReady []HookFn
+	// PreStop runs before the container is stopped. eg to capture state or scrape metrics
+	PreStop []HookFn
+	// PostStop runs after the container has stopped
+	PostStop []HookFn
+}
+
+// runHooks runs the hooks for a lifecycle phase in order, stopping at the first error
+func (c *Container) runHooks(ctx context.Context, phase string, hooks []HookFn) error {
+	for i, hook := range hooks {
+		if err := hook(ctx, c); err != nil {
+			return fmt.Errorf("%s hook %d for %s failed: %w", phase, i+1, c.ContainerName(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package cntest
+
+import (
+	"strings"
+	"testing"
+)
+
+// Variant is one configuration of a container to run the same tests against
+type Variant struct {
+	// Name is the subtest name. eg "postgres:15"
+	Name string
+	// Options configure the container for this variant
+	Options []Option
+	// Serial variants don't run in parallel with the others. eg if they map fixed host ports
+	Serial bool
+}
+
+// Containerised is anything built on a Container, like the db module instances
+type Containerised interface {
+	container() *Container
+}
+
+func (c *Container) container() *Container {
+	return c
+}
+
+// ImageVariants returns a variant for each image, named after the image
+func ImageVariants(images ...string) []Variant {
+	var variants []Variant
+	for _, image := range images {
+		variants = append(variants, Variant{Name: image, Options: []Option{WithImage(image)}})
+	}
+	return variants
+}
+
+// Matrix runs test as a subtest for each variant against a running container made by build
+// with the variant's options. The image is pulled first if it isn't available locally,
+// and the variant fails if it can't be.
+// Variants run in parallel unless they are Serial, so they finish after the calling test
+// function returns. Wrap Matrix in a t.Run if you need it to finish first.
+func Matrix[T Containerised](t *testing.T, variants []Variant, build func(opts ...Option) (T, error), test func(t *testing.T, instance T)) {
+	for _, variant := range variants {
+		variant := variant
+		t.Run(variant.Name, func(t *testing.T) {
+			if !variant.Serial {
+				t.Parallel()
+			}
+			instance, err := build(variant.Options...)
+			if err != nil {
+				t.Fatalf("Unable to configure %s: %v", variant.Name, err)
+			}
+			cnt := instance.container()
+			image, version := splitImage(cnt.Config.Image)
+			if err = Pull(image, version, cnt.GetImageRepoSource); err != nil {
+				t.Fatalf("Unable to pull %s: %v", cnt.Config.Image, err)
+			}
+			ExecuteWithRunningContainer(t, cnt, func(t *testing.T) {
+				test(t, instance)
+			})
+		})
+	}
+}
+
+// splitImage splits an image reference into its name and tag, which defaults to latest like docker
+func splitImage(ref string) (string, string) {
+	colon := strings.LastIndex(ref, ":")
+	if colon < 0 || colon < strings.LastIndex(ref, "/") {
+		return ref, "latest"
+	}
+	return ref[:colon], ref[colon+1:]
+}
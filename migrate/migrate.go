@@ -0,0 +1,178 @@
+// Package migrate applies golang-migrate style NNN_name.up.sql migrations to a db container
+// and records the applied versions in a schema_migrations table so they are only applied once
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cybernostics/cntest"
+	"github.com/jmoiron/sqlx"
+)
+
+// Table records the applied migration versions
+const Table = "schema_migrations"
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.up\.sql$`)
+
+// Migration is one NNN_name.up.sql file
+type Migration struct {
+	Version int64
+	Name    string
+	File    string
+	SQL     string
+}
+
+// Error is returned when a migration statement fails
+type Error struct {
+	// File is the migration file which failed
+	File string
+	// Statement is the failing statement, numbered from 1 within the file
+	Statement int
+	// SQL is the text of the failing statement
+	SQL string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("migration %s failed at statement %d: %v\n%s", e.File, e.Statement, e.Err, e.SQL)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Load reads the NNN_name.up.sql files in the root of fsys in version order
+// Other files such as .down.sql files are ignored
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	files := map[int64]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has a bad version: %w", entry.Name(), err)
+		}
+		if other, ok := files[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", other, entry.Name(), version)
+		}
+		files[version] = entry.Name()
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    match[2],
+			File:    entry.Name(),
+			SQL:     string(content),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Apply runs the migrations in fsys which haven't been applied yet, each in its own transaction,
+// and returns how many were applied. driver is the database/sql driver name eg "postgres"
+// MySQL commits DDL statements like CREATE TABLE straight away, so a mysql migration which
+// fails part way can leave the statements before the failure applied but the migration
+// not recorded. Keep mysql migrations to one DDL statement each if that matters
+func Apply(ctx context.Context, db *sql.DB, driver string, fsys fs.FS) (int, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+	dbx := sqlx.NewDb(db, driver)
+	_, err = dbx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+Table+" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL)")
+	if err != nil {
+		return 0, fmt.Errorf("unable to create %s: %w", Table, err)
+	}
+	var versions []int64
+	if err = dbx.SelectContext(ctx, &versions, "SELECT version FROM "+Table); err != nil {
+		return 0, err
+	}
+	applied := map[int64]bool{}
+	for _, version := range versions {
+		applied[version] = true
+	}
+
+	count := 0
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		if err = apply(ctx, dbx, driver, migration); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// apply runs one migration and records its version
+func apply(ctx context.Context, dbx *sqlx.DB, driver string, migration Migration) error {
+	tx, err := dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, statement := range SplitStatements(driver, migration.SQL) {
+		if _, err = tx.ExecContext(ctx, statement); err != nil {
+			return &Error{File: migration.File, Statement: i + 1, SQL: statement, Err: err}
+		}
+	}
+	_, err = tx.ExecContext(ctx, tx.Rebind("INSERT INTO "+Table+" (version, name) VALUES (?, ?)"), migration.Version, migration.Name)
+	if err != nil {
+		return fmt.Errorf("unable to record migration %s: %w", migration.File, err)
+	}
+	return tx.Commit()
+}
+
+// Hook returns a PostReady hook which applies the migrations over the container's DBConnect connection
+func Hook(fsys fs.FS) cntest.HookFn {
+	return func(ctx context.Context, c *cntest.Container) error {
+		db, err := c.DBConnect(c.MaxStartTimeSeconds)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(c.MaxStartTimeSeconds)*time.Second)
+		defer cancel()
+		count, err := Apply(ctx, db, c.Props[cntest.DriverProp], fsys)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations to %s\n", count, c.ContainerName())
+		return nil
+	}
+}
+
+// With is a container option which applies the migrations once the container is ready
+// The migration files are checked straight away so bad file names are reported by New
+func With(fsys fs.FS) cntest.Option {
+	return func(c *cntest.Container) error {
+		if _, err := Load(fsys); err != nil {
+			return fmt.Errorf("unable to load migrations: %w", err)
+		}
+		c.Hooks.PostReady = append(c.Hooks.PostReady, Hook(fsys))
+		return nil
+	}
+}
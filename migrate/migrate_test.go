@@ -0,0 +1,64 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/migrate"
+)
+
+func TestLoadSortsByVersionAndSkipsOtherFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"010_add_orders.up.sql":   {Data: []byte("create table orders (id int);")},
+		"002_add_agents.up.sql":   {Data: []byte("create table agents (id int);")},
+		"002_add_agents.down.sql": {Data: []byte("drop table agents;")},
+		"README.md":               {Data: []byte("notes")},
+	}
+	migrations, err := migrate.Load(fsys)
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, len(migrations), is.EqualTo(2))
+	then.AssertThat(t, migrations[0].Version, is.EqualTo(int64(2)))
+	then.AssertThat(t, migrations[0].Name, is.EqualTo("add_agents"))
+	then.AssertThat(t, migrations[1].File, is.EqualTo("010_add_orders.up.sql"))
+}
+
+func TestLoadRejectsDuplicateVersions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_first.up.sql":  {Data: []byte("select 1;")},
+		"01_other.up.sql": {Data: []byte("select 2;")},
+	}
+	_, err := migrate.Load(fsys)
+	then.AssertThat(t, err.Error(), is.StringContaining("have the same version 1"))
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- agents; the people
+create table agents (name varchar(40) default 'a;b');
+/* a comment; with a semicolon */
+insert into agents values ('it''s; fine');
+-- only a comment;
+`
+	statements := migrate.SplitStatements("postgres", script)
+	then.AssertThat(t, len(statements), is.EqualTo(2))
+	then.AssertThat(t, statements[0], is.StringContaining("default 'a;b')"))
+	then.AssertThat(t, statements[1], is.EqualTo("/* a comment; with a semicolon */\ninsert into agents values ('it''s; fine')"))
+}
+
+func TestSplitStatementsDollarQuotes(t *testing.T) {
+	script := `create function one() returns int as $body$ begin return 1; end; $body$ language plpgsql;
+select $1;`
+	statements := migrate.SplitStatements("postgres", script)
+	then.AssertThat(t, len(statements), is.EqualTo(2))
+	then.AssertThat(t, statements[0], is.StringContaining("end; $body$ language plpgsql"))
+	then.AssertThat(t, statements[1], is.EqualTo("select $1"))
+}
+
+func TestSplitStatementsMysqlEscapes(t *testing.T) {
+	statements := migrate.SplitStatements("mysql", `insert into t values ('a\';b'); # done; really
+select 1`)
+	then.AssertThat(t, len(statements), is.EqualTo(2))
+	then.AssertThat(t, statements[0], is.EqualTo(`insert into t values ('a\';b')`))
+	then.AssertThat(t, statements[1], is.StringContaining("select 1"))
+}
@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"regexp"
+	"strings"
+)
+
+var dollarQuote = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// SplitStatements splits a sql script into statements on the semicolons between them.
+// Semicolons in quotes, comments and postgres $$ quoted bodies are skipped, and backslash
+// escapes in strings are understood for mysql. Statements with only comments are dropped.
+// The mysql DELIMITER command is not supported
+func SplitStatements(driver string, script string) []string {
+	backslashEscapes := driver == "mysql"
+	var statements []string
+	var current strings.Builder
+	hasCode := false
+	finish := func() {
+		statement := strings.TrimSpace(current.String())
+		if hasCode && len(statement) > 0 {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+		hasCode = false
+	}
+
+	for i := 0; i < len(script); i++ {
+		ch := script[i]
+		rest := script[i:]
+		switch {
+		case ch == ';':
+			finish()
+			continue
+		case strings.HasPrefix(rest, "--") || ch == '#' && backslashEscapes:
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			current.WriteString(rest[:end+1])
+			i += end
+			continue
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			current.WriteString(rest[:end])
+			i += end - 1
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := closingQuote(rest, ch, backslashEscapes && ch != '`')
+			current.WriteString(rest[:end])
+			i += end - 1
+		case ch == '$' && !backslashEscapes:
+			tag := dollarQuote.FindString(rest)
+			if len(tag) == 0 {
+				current.WriteByte(ch)
+				break
+			}
+			end := strings.Index(rest[len(tag):], tag)
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 2 * len(tag)
+			}
+			current.WriteString(rest[:end])
+			i += end - 1
+		default:
+			current.WriteByte(ch)
+		}
+		if !isSpace(ch) {
+			hasCode = true
+		}
+	}
+	finish()
+	return statements
+}
+
+// closingQuote returns the index after the quote closing the string starting at quoted[0]
+func closingQuote(quoted string, quote byte, backslashEscapes bool) int {
+	for i := 1; i < len(quoted); i++ {
+		switch quoted[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case quote:
+			// a doubled quote is an escaped quote
+			if i+1 < len(quoted) && quoted[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(quoted)
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
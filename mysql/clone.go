@@ -0,0 +1,213 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/random"
+)
+
+// rootUser is the mysql admin user. It has no password as the container allows empty passwords
+const rootUser = "root"
+
+// CloneOption changes how NewTestDatabase copies the golden schema
+type CloneOption func(*cloneConfig)
+
+type cloneConfig struct {
+	copyAllRows bool
+	rowTables   map[string]bool
+}
+
+// CopyRows copies the rows of the named tables from the golden schema as well as
+// their definitions. With no table names the rows of every table are copied
+func CopyRows(tables ...string) CloneOption {
+	return func(config *cloneConfig) {
+		if len(tables) == 0 {
+			config.copyAllRows = true
+			return
+		}
+		for _, table := range tables {
+			config.rowTables[table] = true
+		}
+	}
+}
+
+func (c *cloneConfig) copiesRows(table string) bool {
+	return c.copyAllRows || c.rowTables[table]
+}
+
+// NewTestDatabase creates a database for this test and returns its DSN.
+// The instance database, built from initdb_path, is the golden schema. Its tables are
+// recreated in the test database and the test user is granted access to it.
+// Only rows from tables named with CopyRows are copied. The database is dropped
+// when the test finishes. It is safe to call from parallel tests
+func (i *Instance) NewTestDatabase(t testing.TB, opts ...CloneOption) string {
+	t.Helper()
+	config := cloneConfig{rowTables: map[string]bool{}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	i.cloneMu.Lock()
+	name := "test_" + random.Name()
+	err := i.clone(context.Background(), name, config)
+	i.cloneMu.Unlock()
+	if err != nil {
+		t.Fatalf("Unable to create test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := i.drop(context.Background(), name); err != nil {
+			t.Errorf("Unable to drop test database %s: %v", name, err)
+		}
+	})
+	return dsn(i.Container, i.User(), i.Password(), name)
+}
+
+// clone creates the test database and copies the golden schema into it
+// A half built database is dropped again if anything fails
+func (i *Instance) clone(ctx context.Context, name string, config cloneConfig) (err error) {
+	db, err := i.openRoot(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// one connection so the session settings apply to every statement
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	golden := i.DBName()
+	tables, err := goldenTables(ctx, conn, golden)
+	if err != nil {
+		return err
+	}
+
+	exec := func(statement string) error {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("cloning %s into %s failed running %q: %w", golden, name, statement, err)
+		}
+		return nil
+	}
+	if err = exec("CREATE DATABASE " + quoteName(name)); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if _, dropErr := conn.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteName(name)); dropErr != nil {
+			err = errors.Join(err, fmt.Errorf("unable to drop %s: %w", name, dropErr))
+		}
+	}()
+	setup := []string{
+		"USE " + quoteName(name),
+		// tables can then be created and filled in any order
+		"SET FOREIGN_KEY_CHECKS = 0",
+	}
+	for _, statement := range setup {
+		if err = exec(statement); err != nil {
+			return err
+		}
+	}
+	for _, table := range tables {
+		var tableName, createTable string
+		err = conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteName(golden)+"."+quoteName(table)).Scan(&tableName, &createTable)
+		if err != nil {
+			return fmt.Errorf("unable to read the definition of %s.%s: %w", golden, table, err)
+		}
+		if err = exec(createTable); err != nil {
+			return err
+		}
+		if config.copiesRows(table) {
+			var columns string
+			columns, err = storedColumns(ctx, conn, golden, table)
+			if err != nil {
+				return fmt.Errorf("unable to read the columns of %s.%s: %w", golden, table, err)
+			}
+			err = exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s.%s",
+				quoteName(table), columns, columns, quoteName(golden), quoteName(table)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err = exec("SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+		return err
+	}
+	return exec(fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO %s@'%%'", quoteName(name), quoteString(i.User())))
+}
+
+// drop removes a test database
+func (i *Instance) drop(ctx context.Context, name string) error {
+	db, err := i.openRoot(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteName(name))
+	return err
+}
+
+// goldenTables lists the tables in the golden schema
+func goldenTables(ctx context.Context, conn *sql.Conn, golden string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = ? AND table_type = 'BASE TABLE' ORDER BY table_name",
+		golden)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// storedColumns lists the quoted columns of the table which can be inserted into
+// Generated columns are left out as mysql calculates them
+func storedColumns(ctx context.Context, conn *sql.Conn, schema string, table string) (string, error) {
+	rows, err := conn.QueryContext(ctx,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ?"+
+			" AND extra NOT LIKE '%VIRTUAL GENERATED%' AND extra NOT LIKE '%STORED GENERATED%' ORDER BY ordinal_position",
+		schema, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return "", err
+		}
+		columns = append(columns, quoteName(column))
+	}
+	return strings.Join(columns, ", "), rows.Err()
+}
+
+// openRoot connects as root without selecting a database
+func (i *Instance) openRoot(ctx context.Context) (*sql.DB, error) {
+	return cntest.OpenDB(ctx, Driver, dsn(i.Container, rootUser, "", ""))
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
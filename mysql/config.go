@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cybernostics/cntest"
+)
+
+// serverCommand starts the server when settings are passed on the command line
+const serverCommand = "mysqld"
+
+// configDir is read by mysqld in all the official images
+const configDir = "/etc/mysql/conf.d"
+
+// fastDataDir is the tmpfs data dir used in fast mode
+const fastDataDir = "/cntest/mysql-tmpfs"
+
+// fastSettings stop innodb flushing its log and writing pages twice on every commit.
+// A crash loses the last second of transactions, which doesn't matter for a throwaway test db
+var fastSettings = map[string]string{
+	"innodb_flush_log_at_trx_commit": "0",
+	// OFF works for the boolean in 5.7 and the enum in 8.0.30 and later
+	"innodb_doublewrite": "OFF",
+}
+
+// settingArgs spells a server variable as a mysqld option
+// They are passed on the command line, so the image's entrypoint also uses them
+// when it initialises the db on first boot
+func settingArgs(key string, value string) []string {
+	return []string{"--" + key + "=" + value}
+}
+
+// WithSetting sets a mysqld server variable with a --key=value server arg
+// eg mysql.WithSetting("max_connections", "200")
+func WithSetting(key string, value string) cntest.Option {
+	return WithSettings(map[string]string{key: value})
+}
+
+// WithSettings sets several mysqld server variables
+func WithSettings(settings map[string]string) cntest.Option {
+	return cntest.WithServerSettings(serverCommand, settingArgs, settings)
+}
+
+// WithSQLMode sets the server sql_mode. eg mysql.WithSQLMode("STRICT_TRANS_TABLES", "NO_ZERO_DATE")
+// No modes sets it to an empty sql_mode
+func WithSQLMode(modes ...string) cntest.Option {
+	return WithSetting("sql_mode", strings.Join(modes, ","))
+}
+
+// WithCharset sets the server character set and collation. eg mysql.WithCharset("utf8mb4", "utf8mb4_unicode_ci")
+func WithCharset(charset string, collation string) cntest.Option {
+	return WithSettings(map[string]string{
+		"character_set_server": charset,
+		"collation_server":     collation,
+	})
+}
+
+// WithLowerCaseTableNames sets lower_case_table_names to 0, 1 or 2
+// mysql 8 only allows it to be set when the db is initialised, which this does
+func WithLowerCaseTableNames(value int) cntest.Option {
+	return func(cnt *cntest.Container) error {
+		if value < 0 || value > 2 {
+			return fmt.Errorf("lower_case_table_names must be 0, 1 or 2 not %d", value)
+		}
+		return WithSetting("lower_case_table_names", fmt.Sprint(value))(cnt)
+	}
+}
+
+// WithTimeZone sets the server's default time zone
+// Use an offset like "+00:00". Named zones like "Europe/London" need the time zone
+// tables, which the official images don't load by default
+func WithTimeZone(zone string) cntest.Option {
+	return WithSetting("default_time_zone", zone)
+}
+
+// WithConfigFile mounts a my.cnf fragment from the host into /etc/mysql/conf.d
+// The file name must end in .cnf for mysqld to read it
+func WithConfigFile(path string) cntest.Option {
+	return func(cnt *cntest.Container) error {
+		name := filepath.Base(path)
+		if filepath.Ext(name) != ".cnf" {
+			return fmt.Errorf("mysql config file %s must have a .cnf extension", path)
+		}
+		cnt.AddPathMap(cntest.HostPath(path), cntest.ContainerPath(configDir+"/"+name))
+		return nil
+	}
+}
+
+// WithFastMode relaxes innodb's flushing, turns off the binary log and puts the data dir
+// on a tmpfs. Nothing survives the container stopping, so Restart, WhileDown and
+// WithSnapshot can't be used with it. It works with all the Images
+func WithFastMode() cntest.Option {
+	return func(cnt *cntest.Container) error {
+		if err := setDataDir(cnt, fastDataDir); err != nil {
+			return fmt.Errorf("fast mode keeps the data in memory: %w", err)
+		}
+		if err := cntest.WithTmpfs(fastDataDir)(cnt); err != nil {
+			return err
+		}
+		// the image can still be changed by later options
+		cnt.Hooks.PreCreate = append(cnt.Hooks.PreCreate, func(ctx context.Context, cnt *cntest.Container) error {
+			if !isMysql5(cnt.Config.Image) {
+				cntest.AddServerArgs(cnt, serverCommand, "--skip-log-bin")
+			}
+			return nil
+		})
+		return WithSettings(fastSettings)(cnt)
+	}
+}
+
+// isMysql5 reports whether the image is mysql 5, where the binary log is off by default
+// and --skip-log-bin isn't needed
+func isMysql5(image string) bool {
+	return strings.Contains(image, ":5.") || strings.HasSuffix(image, ":5")
+}
+
+// setDataDir starts mysqld with its data in dir
+func setDataDir(cnt *cntest.Container, dir string) error {
+	if err := cntest.SetDataDir(cnt, dir); err != nil {
+		return err
+	}
+	cntest.AddServerArgs(cnt, serverCommand, "--datadir="+dir)
+	return nil
+}
@@ -0,0 +1,54 @@
+package mysql_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/mysql"
+)
+
+func TestTypedOptionsAreMysqldArgs(t *testing.T) {
+	db, err := mysql.New(
+		mysql.WithSQLMode("STRICT_TRANS_TABLES", "NO_ZERO_DATE"),
+		mysql.WithCharset("utf8mb4", "utf8mb4_unicode_ci"),
+		mysql.WithLowerCaseTableNames(1),
+		mysql.WithTimeZone("+00:00"),
+		mysql.WithFastMode(),
+	)
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, []string(db.Config.Cmd), is.EqualTo([]string{
+		"mysqld",
+		"--sql_mode=STRICT_TRANS_TABLES,NO_ZERO_DATE",
+		"--character_set_server=utf8mb4",
+		"--collation_server=utf8mb4_unicode_ci",
+		"--lower_case_table_names=1",
+		"--default_time_zone=+00:00",
+		"--datadir=/cntest/mysql-tmpfs",
+		"--innodb_doublewrite=OFF",
+		"--innodb_flush_log_at_trx_commit=0",
+	}))
+	then.AssertThat(t, db.HostConfig.Tmpfs, has.AllKeys[string, string]("/cntest/mysql-tmpfs"))
+}
+
+func TestBadOptionsAreReported(t *testing.T) {
+	_, err := mysql.New(mysql.WithLowerCaseTableNames(3), mysql.WithConfigFile("../fixtures/mysql.conf"))
+	then.AssertThat(t, err.Error(), is.StringContaining("lower_case_table_names must be 0, 1 or 2 not 3"))
+	then.AssertThat(t, err.Error(), is.StringContaining("must have a .cnf extension"))
+}
+
+func TestFastModeSkipsTheBinaryLogFromMysql8(t *testing.T) {
+	for image, skipped := range map[string]bool{"mysql:5.7": false, "mysql:8.0": true, "mysql:8.4": true} {
+		t.Run(image, func(t *testing.T) {
+			db, err := mysql.New(mysql.WithFastMode(), cntest.WithImage(image))
+			then.AssertThat(t, err, is.Nil())
+			for _, hook := range db.Hooks.PreCreate {
+				then.AssertThat(t, hook(context.Background(), db.Container), is.Nil())
+			}
+			then.AssertThat(t, db.Config.Cmd[len(db.Config.Cmd)-1] == "--skip-log-bin", is.EqualTo(skipped))
+		})
+	}
+}
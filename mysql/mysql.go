@@ -1,20 +1,150 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"strings"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
 	"time"
 
-	// register the mysql driver
 	"github.com/cybernostics/cntest"
-	"github.com/cybernostics/cntest/random"
-
-	// if you import the mysql test config you want to test mysql
-	_ "github.com/go-sql-driver/mysql"
-	"golang.org/x/net/context"
+	"github.com/cybernostics/cntest/migrate"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
 )
 
+// Driver is the database/sql driver name for mysql
+const Driver = "mysql"
+
+// Images are the mysql versions supported, for running tests against each with cntest.Matrix
+var Images = []string{"mysql:5.7", "mysql:8.0", "mysql:8.4"}
+
+// Variants returns a cntest.Matrix variant for each of the images, or for Images if none are given
+func Variants(images ...string) []cntest.Variant {
+	if len(images) == 0 {
+		images = Images
+	}
+	return cntest.ImageVariants(images...)
+}
+
+// Instance is a mysql container created by New
+type Instance struct {
+	*cntest.Container
+
+	// cloneMu serialises creating test databases
+	cloneMu sync.Mutex
+}
+
+var _ cntest.Database = (*Instance)(nil)
+
+// Driver returns the database/sql driver name
+func (i *Instance) Driver() string {
+	return Driver
+}
+
+// DSN returns the go-sql-driver connection string for the database
+func (i *Instance) DSN() string {
+	return dsn(i.Container, i.User(), i.Password(), i.DBName())
+}
+
+// Host returns the address to connect to
+func (i *Instance) Host() string {
+	return cntest.DBHost
+}
+
+// User returns the db user name
+func (i *Instance) User() string {
+	return i.Props[cntest.DBUserProp]
+}
+
+// Password returns the db user password
+func (i *Instance) Password() string {
+	return i.Props[cntest.DBPassProp]
+}
+
+// DBName returns the name of the database
+func (i *Instance) DBName() string {
+	return i.Props[cntest.DBNameProp]
+}
+
+// Open connects to the database
+func (i *Instance) Open(ctx context.Context) (*sql.DB, error) {
+	return cntest.OpenDB(ctx, Driver, i.DSN())
+}
+
+// OpenSQLX connects to the database with sqlx
+func (i *Instance) OpenSQLX(ctx context.Context) (*sqlx.DB, error) {
+	return cntest.OpenSQLX(ctx, Driver, i.DSN())
+}
+
+// dsn builds the connection string for a user and database
+func dsn(cnt *cntest.Container, user string, password string, dbName string) string {
+	config := mysqldriver.NewConfig()
+	config.User = user
+	config.Passwd = password
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(cntest.DBHost, cnt.HostPort())
+	config.DBName = dbName
+	return config.FormatDSN()
+}
+
+// New creates a mysql container. The defaults are applied first so opts can override them
+// eg mysql.New(mysql.WithDatabase("orders"), mysql.WithInitDB("./testdb"), cntest.WithImage("mysql:8.4"))
+// All the configuration problems are returned together before anything is sent to docker
+func New(opts ...cntest.Option) (*Instance, error) {
+	cnt, err := cntest.New(append([]cntest.Option{Config(cntest.PropertyMap{})}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{Container: cnt}, nil
+}
+
+// WithDatabase sets the name of the database
+func WithDatabase(name string) cntest.Option {
+	return cntest.WithDBName(name)
+}
+
+// WithUser sets the database user and password
+func WithUser(user string, password string) cntest.Option {
+	return cntest.WithDBUser(user, password)
+}
+
+// WithInitDB mounts a folder of sh and sql files executed in lexical order when the db first starts
+func WithInitDB(sqlPath string) cntest.Option {
+	return cntest.WithInitDB(sqlPath)
+}
+
+// WithMigrations applies the NNN_name.up.sql migrations in fsys once the db is ready
+// Unlike initdb scripts they work with remote docker daemons and are only applied once
+func WithMigrations(fsys fs.FS) cntest.Option {
+	return migrate.With(fsys)
+}
+
+// WithMigrationsDir applies the NNN_name.up.sql migrations in dir once the db is ready
+func WithMigrationsDir(dir string) cntest.Option {
+	return migrate.With(os.DirFS(dir))
+}
+
+// snapshotDataDir holds the data when snapshotting as docker doesn't commit the image's data VOLUME
+const snapshotDataDir = "/cntest/mysql"
+
+// WithSnapshot commits the container to a local image once the init scripts and any
+// earlier PostReady hooks such as migrations have run, and starts from it next time
+// See cntest.WithSnapshot. The db name, user and password default to "cntest" instead of
+// random values so they match the snapshot.
+func WithSnapshot(keys ...string) cntest.Option {
+	return func(cnt *cntest.Container) error {
+		cntest.SetFixedDBCredentials(cnt.Props)
+		if err := setDataDir(cnt, snapshotDataDir); err != nil {
+			return fmt.Errorf("snapshots need the data on disk: %w", err)
+		}
+		return cntest.WithSnapshot(keys...)(cnt)
+	}
+}
+
 // Container creates a mysql opinionated container with defaults overridden by the
 // supplied props for:
 //
@@ -22,74 +152,52 @@ import (
 //	dbuser - user name for the database. defaults to random
 //	dbpass - user password for the database. defaults to random
 //	initdb_path - folder containing sh and sql files executed in lexical order when the container db starts
+//
+// It returns nil if the container can't be configured. Use New to get the error instead
 func Container(props cntest.PropertyMap) *cntest.Container {
 	return cntest.ContainerWith(Config(props))
 }
 
 // Config func to generate a configurer to use like this
-// cntest.NewContainer(mysql.Config({"db":"mydb","initdb_path":"./testdb",user:"bob"}))
+// cntest.New(mysql.Config(cntest.PropertyMap{"db":"mydb","initdb_path":"./testdb"}))
+// Random values are filled in for the db name, user and password props which aren't set.
+// The env is set from the container props when the container is created so later options can change them
 func Config(props cntest.PropertyMap) func(*cntest.Container) error {
-	driver := "mysql"
-	props["driver"] = driver
-	dbName := props.GetOrDefault("db", random.Name())
-	props["db"] = dbName
-	dbUser := props.GetOrDefault("dbuser", random.Name())
-	props["dbuser"] = dbUser
-	dbPass := props.GetOrDefault("dbpass", random.Name())
-	props["dbpass"] = dbPass
-
-	env := map[string]string{
-		"MYSQL_ALLOW_EMPTY_PASSWORD": "true",
-		"MYSQL_DATABASE":             dbName,
-		"MYSQL_USER":                 dbUser,
-		"MYSQL_PASSWORD":             dbPass,
-	}
+	image := props.GetOrDefault("image", "mysql:8")
+	props[cntest.DriverProp] = Driver
+	props.SetRandomIfMissing(cntest.DBNameProp, cntest.DBUserProp, cntest.DBPassProp)
 
 	return func(cnt *cntest.Container) error {
 		cnt.Props.SetAll(props)
-		cnt.AddAllEnv(env)
-		cnt.WithImage("mysql:8")
+		cnt.WithImage(image)
 		cnt.SetAppPort("3306")
-		if sqlPath, ok := props["initdb_path"]; ok {
-			cnt.AddPathMap(cntest.HostPath(sqlPath), cntest.ContainerPath("/docker-entrypoint-initdb.d"))
+		if sqlPath, ok := props[cntest.InitDBProp]; ok {
+			if err := WithInitDB(sqlPath)(cnt); err != nil {
+				return err
+			}
 		}
+		cnt.Hooks.PreCreate = append(cnt.Hooks.PreCreate, func(ctx context.Context, cnt *cntest.Container) error {
+			cnt.AddAllEnv(map[string]string{
+				"MYSQL_ALLOW_EMPTY_PASSWORD": "true",
+				"MYSQL_DATABASE":             cnt.Props[cntest.DBNameProp],
+				"MYSQL_USER":                 cnt.Props[cntest.DBUserProp],
+				"MYSQL_PASSWORD":             cnt.Props[cntest.DBPassProp],
+			})
+			return nil
+		})
 		cnt.DBConnect = func(timeoutSeconds int) (*sql.DB, error) {
-			connStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", dbUser, dbPass, "127.0.0.1", cnt.HostPort(), dbName)
-			db, err := sql.Open(driver, connStr)
-
-			// if there is an error opening the connection, handle it
-			if err != nil {
-				return nil, err
-			}
-
-			a, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
 			defer cancel()
-
-			err = db.PingContext(a)
-			if err != nil {
-				return nil, err
-			}
-			return db, nil
+			return cntest.OpenDB(ctx, Driver, dsn(cnt, cnt.Props[cntest.DBUserProp], cnt.Props[cntest.DBPassProp], cnt.Props[cntest.DBNameProp]))
 		}
-		cnt.ContainerReady = func() (bool, error) {
-			db, err := cnt.DBConnect(5)
-			if err != nil {
-				if strings.Contains(err.Error(), "connection refused") {
-					return false, nil
-				}
-				if strings.Contains(err.Error(), "EOF") {
-					return false, nil
-				}
-				if strings.Contains(err.Error(), "driver: bad connection") {
-					return false, nil
-				}
-
-				fmt.Printf("Error %v\n", err)
-				return false, err
+		cnt.ConnectCommands = func() []string {
+			dbName, dbUser, dbPass := cnt.Props[cntest.DBNameProp], cnt.Props[cntest.DBUserProp], cnt.Props[cntest.DBPassProp]
+			return []string{
+				fmt.Sprintf("mysql -h 127.0.0.1 -P %s -u %s -p%s %s", cnt.HostPort(), dbUser, dbPass, dbName),
+				fmt.Sprintf("docker exec -it %s mysql -u %s -p%s %s", cnt.ContainerName(), dbUser, dbPass, dbName),
 			}
-			defer db.Close()
-			return true, nil
 		}
+		cnt.ContainerReady = containerReady(cnt)
 		return nil
 	}
 }
@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cybernostics/cntest"
+)
+
+// Log lines written by the official image as it starts
+const (
+	// initStarted is written when the entrypoint initialises a new db
+	initStarted = "Initializing database files"
+	// initDone is written once the init scripts have run and the temporary server has stopped
+	initDone = "MySQL init process done. Ready for start up."
+	// serverReady is written by the temporary init server and the final server
+	serverReady = "mysqld: ready for connections"
+)
+
+// serverPort finds the port in the ready message. mysql 5.7 puts it on the next line
+var serverPort = regexp.MustCompile(`port: (\d+)`)
+
+// ReadyInLogs reports whether the logs show the final mysql server is accepting connections.
+// While the image initialises a new db it runs a temporary server, which also says it is ready
+// for connections but on port 0, so only a ready message with a real port after init counts
+func ReadyInLogs(logs string) bool {
+	if done := strings.LastIndex(logs, initDone); done >= 0 {
+		logs = logs[done:]
+	} else if strings.Contains(logs, initStarted) {
+		return false
+	}
+	lines := strings.Split(logs, "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, serverReady) {
+			continue
+		}
+		message := line
+		if i+1 < len(lines) {
+			message += "\n" + lines[i+1]
+		}
+		if port := serverPort.FindStringSubmatch(message); port != nil && port[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// containerReady waits for the final server in the logs and then for a query to work
+func containerReady(cnt *cntest.Container) cntest.ContainerReadyFn {
+	return func() (bool, error) {
+		if running, err := cnt.IsRunning(); !running || err != nil {
+			return false, err
+		}
+		logs, err := cnt.Logs()
+		if err != nil {
+			return false, err
+		}
+		if !ReadyInLogs(logs) {
+			return false, nil
+		}
+
+		db, err := cnt.DBConnect(5)
+		if err != nil {
+			return false, cntest.RetryableDBError(err)
+		}
+		defer db.Close()
+		if _, err = db.Exec("SELECT 1"); err != nil {
+			return false, cntest.RetryableDBError(err)
+		}
+		return true, nil
+	}
+}
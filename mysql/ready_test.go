@@ -0,0 +1,27 @@
+package mysql_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/mysql"
+)
+
+func TestReadyInLogs(t *testing.T) {
+	transcripts := map[string]bool{
+		"fresh-init.log":            true,
+		"temporary-server.log":      false,
+		"final-server-starting.log": false,
+		"existing-data.log":         true,
+		"mysql57-fresh-init.log":    true,
+	}
+	for file, ready := range transcripts {
+		t.Run(file, func(t *testing.T) {
+			logs, err := os.ReadFile("testdata/" + file)
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, mysql.ReadyInLogs(string(logs)), is.EqualTo(ready))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/migrate"
+)
+
+// Reset empties the tables in the instance database, resetting their auto increments,
+// then runs any reload. The schema_migrations table and excluded tables keep their rows
+func (i *Instance) Reset(ctx context.Context, opts ...cntest.ResetOption) error {
+	options := cntest.NewResetOptions(append([]cntest.ResetOption{cntest.ExcludeTables(migrate.Table)}, opts...)...)
+	db, err := i.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// one connection so foreign key checks stay off for every truncate
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		if !options.Excludes(table) {
+			tables = append(tables, table)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err = conn.ExecContext(ctx, "TRUNCATE TABLE "+quoteName(table)); err != nil {
+			return err
+		}
+	}
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+		return err
+	}
+	if options.Reload != nil {
+		return options.Reload(ctx, db, Driver)
+	}
+	return nil
+}
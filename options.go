@@ -0,0 +1,153 @@
+package cntest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Option configures a container created with New
+// Module Config fns are Options too
+type Option = ContainerConfigFn
+
+// New creates a container configured by the options, applied in order.
+// All the option errors and configuration problems found by Validate are
+// reported together, before anything is sent to docker.
+func New(opts ...Option) (*Container, error) {
+	cnt := NewContainer()
+	var problems []error
+	for _, opt := range opts {
+		if err := opt(cnt); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if err := cnt.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return cnt, nil
+}
+
+// WithImage sets the docker image. eg "postgres:16"
+func WithImage(image string) Option {
+	return func(c *Container) error {
+		if len(image) == 0 {
+			return errors.New("image must not be empty")
+		}
+		c.WithImage(image)
+		return nil
+	}
+}
+
+// WithName sets the container name instead of a random one
+func WithName(name string) Option {
+	return func(c *Container) error {
+		c.SetName(name)
+		return nil
+	}
+}
+
+// WithEnv adds an environment variable to the container
+func WithEnv(key string, value string) Option {
+	return func(c *Container) error {
+		if len(key) == 0 {
+			return fmt.Errorf("env var with value %q has no name", value)
+		}
+		c.AddEnv(key, value)
+		return nil
+	}
+}
+
+// WithPort sets the main container port and maps it to a random host port
+func WithPort(port string) Option {
+	return func(c *Container) error {
+		if err := checkPort(port); err != nil {
+			return err
+		}
+		c.SetAppPort(port)
+		return nil
+	}
+}
+
+// WithMount mounts a host path into the container like the -v cmd switch
+func WithMount(host HostPath, container ContainerPath) Option {
+	return func(c *Container) error {
+		c.AddPathMap(host, container)
+		return nil
+	}
+}
+
+// WithLabels adds docker labels to the container
+func WithLabels(labels map[string]string) Option {
+	return func(c *Container) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for key, value := range labels {
+			c.Config.Labels[key] = value
+		}
+		return nil
+	}
+}
+
+// WithProps sets container props. eg a db name for a db module
+func WithProps(props PropertyMap) Option {
+	return func(c *Container) error {
+		c.Props.SetAll(props)
+		return nil
+	}
+}
+
+// WithReadiness sets the check used by AwaitIsReady
+func WithReadiness(ready ContainerReadyFn) Option {
+	return func(c *Container) error {
+		c.ContainerReady = ready
+		return nil
+	}
+}
+
+// WithMaxStartTime sets how long AwaitIsReady waits for the container to be ready
+func WithMaxStartTime(timeoutSeconds int) Option {
+	return func(c *Container) error {
+		c.MaxStartTimeSeconds = timeoutSeconds
+		return nil
+	}
+}
+
+// Validate checks the container config and reports all the problems found
+// It doesn't talk to docker so it is cheap to call before starting a container
+func (c *Container) Validate() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var problems []error
+	if len(c.Config.Image) == 0 {
+		problems = append(problems, errors.New("no image set"))
+	}
+	if c.MaxStartTimeSeconds <= 0 {
+		problems = append(problems, fmt.Errorf("max start time must be positive but was %d seconds", c.MaxStartTimeSeconds))
+	}
+	if c.ContainerReady == nil {
+		problems = append(problems, errors.New("no readiness check set"))
+	}
+	for _, m := range c.HostConfig.Mounts {
+		if m.Type != mount.TypeBind {
+			continue
+		}
+		if _, err := os.Stat(m.Source); err != nil {
+			problems = append(problems, fmt.Errorf("mount source %s for %s does not exist", m.Source, m.Target))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+func checkPort(port string) error {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
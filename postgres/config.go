@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/cybernostics/cntest"
+)
+
+// serverCommand starts the server when settings are passed on the command line
+const serverCommand = "postgres"
+
+// configFilePath is where WithConfigFile mounts the config file in the container
+const configFilePath = "/etc/postgresql/postgresql.conf"
+
+// fastDataDir is the tmpfs PGDATA used in fast mode
+const fastDataDir = "/cntest/pgdata-tmpfs"
+
+// fastSettings stop postgres waiting for writes to reach the disk. A crash can lose
+// or corrupt the data, which doesn't matter for a throwaway test db
+var fastSettings = map[string]string{
+	"fsync":              "off",
+	"synchronous_commit": "off",
+	"full_page_writes":   "off",
+}
+
+// settingArgs spells a postgresql.conf setting as a server arg
+func settingArgs(key string, value string) []string {
+	return []string{"-c", key + "=" + value}
+}
+
+// WithSetting sets a postgresql.conf setting with a -c key=value server arg
+// eg postgres.WithSetting("max_connections", "200")
+func WithSetting(key string, value string) cntest.Option {
+	return WithSettings(map[string]string{key: value})
+}
+
+// WithSettings sets several postgresql.conf settings
+func WithSettings(settings map[string]string) cntest.Option {
+	return cntest.WithServerSettings(serverCommand, settingArgs, settings)
+}
+
+// WithConfigFile mounts a postgresql.conf file from the host and starts the server with it
+// Settings from WithSetting override the ones in the file. The server always listens on all
+// addresses, as the image's own config does, so the mapped port can be reached
+func WithConfigFile(path string) cntest.Option {
+	return func(cnt *cntest.Container) error {
+		cnt.AddPathMap(cntest.HostPath(path), cntest.ContainerPath(configFilePath))
+		cntest.AddServerArgs(cnt, serverCommand, "-c", "config_file="+configFilePath, "-c", "listen_addresses=*")
+		return nil
+	}
+}
+
+// WithFastMode turns off fsync, synchronous_commit and full_page_writes and puts PGDATA
+// on a tmpfs. The data is gone once the container stops, so the container can't be
+// restarted with Restart or WhileDown, and it can't be used with WithSnapshot
+func WithFastMode() cntest.Option {
+	return func(cnt *cntest.Container) error {
+		if err := setDataDir(cnt, fastDataDir); err != nil {
+			return fmt.Errorf("fast mode keeps the data in memory: %w", err)
+		}
+		if err := cntest.WithTmpfs(fastDataDir)(cnt); err != nil {
+			return err
+		}
+		return WithSettings(fastSettings)(cnt)
+	}
+}
+
+// setDataDir points PGDATA at dir
+func setDataDir(cnt *cntest.Container, dir string) error {
+	if err := cntest.SetDataDir(cnt, dir); err != nil {
+		return err
+	}
+	cnt.AddEnv("PGDATA", dir)
+	return nil
+}
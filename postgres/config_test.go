@@ -0,0 +1,39 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/corbym/gocrest/has"
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/postgres"
+)
+
+func TestSettingsArePassedWithDashC(t *testing.T) {
+	pg, err := postgres.New(postgres.WithSetting("max_connections", "200"), postgres.WithFastMode())
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, []string(pg.Config.Cmd), is.EqualTo([]string{
+		"postgres",
+		"-c", "max_connections=200",
+		"-c", "fsync=off",
+		"-c", "full_page_writes=off",
+		"-c", "synchronous_commit=off",
+	}))
+	then.AssertThat(t, pg.Config.Env, is.ArrayContaining("PGDATA=/cntest/pgdata-tmpfs"))
+	then.AssertThat(t, pg.HostConfig.Tmpfs, has.AllKeys[string, string]("/cntest/pgdata-tmpfs"))
+}
+
+func TestConfigFileIsMountedAndListensOnAllAddresses(t *testing.T) {
+	pg, err := postgres.New(postgres.WithConfigFile("testdata/postgresql.conf"))
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, []string(pg.Config.Cmd), is.EqualTo([]string{
+		"postgres",
+		"-c", "config_file=/etc/postgresql/postgresql.conf",
+		"-c", "listen_addresses=*",
+	}))
+	mounts := pg.HostConfig.Mounts
+	then.AssertThat(t, mounts[len(mounts)-1].Target, is.EqualTo("/etc/postgresql/postgresql.conf"))
+
+	_, err = postgres.New(postgres.WithConfigFile("testdata/no-such.conf"))
+	then.AssertThat(t, err.Error(), is.StringContaining("does not exist"))
+}
@@ -4,129 +4,206 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
-	// register the mysql driver
 	"github.com/cybernostics/cntest"
-	"github.com/cybernostics/cntest/random"
+	"github.com/cybernostics/cntest/migrate"
+	"github.com/jmoiron/sqlx"
 
-	// if you import the postgres test config you want to test postgres
+	// register the postgres driver
 	_ "github.com/lib/pq"
 )
 
-// Container creates a mysql opinionated container with defaults overridden by the
+// Driver is the database/sql driver name for postgres
+const Driver = "postgres"
+
+// Images are the postgres versions supported, for running tests against each with cntest.Matrix
+var Images = []string{"postgres:13", "postgres:14", "postgres:15", "postgres:16"}
+
+// Variants returns a cntest.Matrix variant for each of the images, or for Images if none are given
+func Variants(images ...string) []cntest.Variant {
+	if len(images) == 0 {
+		images = Images
+	}
+	return cntest.ImageVariants(images...)
+}
+
+// Instance is a postgres container created by New
+type Instance struct {
+	*cntest.Container
+
+	// templateMu guards template and serialises the test database clones
+	templateMu sync.Mutex
+	// template is the database test databases are cloned from once MakeTemplate is called
+	template string
+}
+
+var _ cntest.Database = (*Instance)(nil)
+
+// Driver returns the database/sql driver name
+func (i *Instance) Driver() string {
+	return Driver
+}
+
+// DSN returns the connection url for the database
+func (i *Instance) DSN() string {
+	return dsn(i.Container, i.DBName())
+}
+
+// Host returns the address to connect to
+func (i *Instance) Host() string {
+	return cntest.DBHost
+}
+
+// User returns the db user name
+func (i *Instance) User() string {
+	return i.Props[cntest.DBUserProp]
+}
+
+// Password returns the db user password
+func (i *Instance) Password() string {
+	return i.Props[cntest.DBPassProp]
+}
+
+// DBName returns the name of the database
+func (i *Instance) DBName() string {
+	return i.Props[cntest.DBNameProp]
+}
+
+// Open connects to the database
+func (i *Instance) Open(ctx context.Context) (*sql.DB, error) {
+	return cntest.OpenDB(ctx, Driver, i.DSN())
+}
+
+// OpenSQLX connects to the database with sqlx
+func (i *Instance) OpenSQLX(ctx context.Context) (*sqlx.DB, error) {
+	return cntest.OpenSQLX(ctx, Driver, i.DSN())
+}
+
+// dsn builds the connection url for a database from the container props
+func dsn(cnt *cntest.Container, dbName string) string {
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cnt.Props[cntest.DBUserProp], cnt.Props[cntest.DBPassProp]),
+		Host:     net.JoinHostPort(cntest.DBHost, cnt.HostPort()),
+		Path:     "/" + dbName,
+		RawQuery: "sslmode=disable",
+	}
+	return connURL.String()
+}
+
+// New creates a postgres container. The defaults are applied first so opts can override them
+// eg postgres.New(postgres.WithDatabase("orders"), postgres.WithInitDB("./testdb"), cntest.WithImage("postgres:16"))
+// All the configuration problems are returned together before anything is sent to docker
+func New(opts ...cntest.Option) (*Instance, error) {
+	cnt, err := cntest.New(append([]cntest.Option{Config(cntest.PropertyMap{})}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Instance{Container: cnt}, nil
+}
+
+// WithDatabase sets the name of the database
+func WithDatabase(name string) cntest.Option {
+	return cntest.WithDBName(name)
+}
+
+// WithUser sets the database user and password
+func WithUser(user string, password string) cntest.Option {
+	return cntest.WithDBUser(user, password)
+}
+
+// WithInitDB mounts a folder of sh and sql files executed in lexical order when the db first starts
+func WithInitDB(sqlPath string) cntest.Option {
+	return cntest.WithInitDB(sqlPath)
+}
+
+// WithMigrations applies the NNN_name.up.sql migrations in fsys once the db is ready
+// Unlike initdb scripts they work with remote docker daemons and are only applied once
+func WithMigrations(fsys fs.FS) cntest.Option {
+	return migrate.With(fsys)
+}
+
+// WithMigrationsDir applies the NNN_name.up.sql migrations in dir once the db is ready
+func WithMigrationsDir(dir string) cntest.Option {
+	return migrate.With(os.DirFS(dir))
+}
+
+// snapshotDataDir holds the data when snapshotting as docker doesn't commit the image's data VOLUME
+const snapshotDataDir = "/cntest/pgdata"
+
+// WithSnapshot commits the container to a local image once the init scripts and any
+// earlier PostReady hooks such as migrations have run, and starts from it next time
+// See cntest.WithSnapshot. The db name, user and password default to "cntest" instead of
+// random values so they match the snapshot.
+func WithSnapshot(keys ...string) cntest.Option {
+	return func(cnt *cntest.Container) error {
+		cntest.SetFixedDBCredentials(cnt.Props)
+		if err := setDataDir(cnt, snapshotDataDir); err != nil {
+			return fmt.Errorf("snapshots need the data on disk: %w", err)
+		}
+		return cntest.WithSnapshot(keys...)(cnt)
+	}
+}
+
+// Container creates a postgres opinionated container with defaults overridden by the
 // supplied props for:
 // db - name of the database. defaults to random
 // dbuser - user name for the database. defaults to random
 // dbpass - user password for the database. defaults to random
-// sql - folder containing sh and sql files executed in lexical order when the container db starts
+// initdb_path - folder containing sh and sql files executed in lexical order when the container db starts
+// It returns nil if the container can't be configured. Use New to get the error instead
 func Container(props cntest.PropertyMap) *cntest.Container {
 	return cntest.ContainerWith(Config(props))
 }
 
 // Config func to generate a configurer to use like this
-// cntest.NewContainer(mysql.Config({db:"mydb",sqlFolder:"./testdb",user:"bob"}))
+// cntest.New(postgres.Config(cntest.PropertyMap{"db":"mydb","initdb_path":"./testdb"}))
+// Random values are filled in for the db name, user and password props which aren't set.
+// The env is set from the container props when the container is created so later options can change them
 func Config(props cntest.PropertyMap) func(*cntest.Container) error {
-	driver := "postgres"
-	image  := props.GetOrDefault("image","postgres:13")
-	props["driver"] = driver
-	dbName := props.GetOrDefault("db", random.Name())
-	props["db"] = dbName
-	dbUser := props.GetOrDefault("dbuser", random.Name())
-	props["dbuser"] = dbUser
-	dbPass := props.GetOrDefault("dbpass", random.Name())
-	props["dbpass"] = dbPass
-	env := map[string]string{
-		"POSTGRES_ALLOW_EMPTY_PASSWORD": "true",
-		"POSTGRES_DB":                   dbName,
-		"POSTGRES_USER":                 dbUser,
-		"POSTGRES_PASSWORD":             dbPass,
-	}
+	image := props.GetOrDefault("image", "postgres:13")
+	props[cntest.DriverProp] = Driver
+	props.SetRandomIfMissing(cntest.DBNameProp, cntest.DBUserProp, cntest.DBPassProp)
 
 	return func(cnt *cntest.Container) error {
 		cnt.Props.SetAll(props)
-		cnt.AddAllEnv(env)
 		cnt.WithImage(image)
 		cnt.SetAppPort("5432")
-		if sqlPath, ok := props["initdb_path"]; ok {
-			if _, err := os.Stat(sqlPath); os.IsNotExist(err) {
-				// path/to/whatever does not exist
-				return fmt.Errorf("initdb_path does not exist. This should point to the db init scripts")
+		if sqlPath, ok := props[cntest.InitDBProp]; ok {
+			if err := WithInitDB(sqlPath)(cnt); err != nil {
+				return err
 			}
-			cnt.AddPathMap(cntest.HostPath(sqlPath),
-				cntest.ContainerPath("/docker-entrypoint-initdb.d"))
 		}
+		cnt.Hooks.PreCreate = append(cnt.Hooks.PreCreate, func(ctx context.Context, cnt *cntest.Container) error {
+			cnt.AddAllEnv(map[string]string{
+				"POSTGRES_ALLOW_EMPTY_PASSWORD": "true",
+				"POSTGRES_DB":                   cnt.Props[cntest.DBNameProp],
+				"POSTGRES_USER":                 cnt.Props[cntest.DBUserProp],
+				"POSTGRES_PASSWORD":             cnt.Props[cntest.DBPassProp],
+			})
+			return nil
+		})
 		cnt.DBConnect = func(timeoutSeconds int) (*sql.DB, error) {
-			connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-				dbUser,
-				dbPass,
-				"127.0.0.1",
-				cnt.HostPort(),
-				dbName)
-			db, err := sql.Open(driver, connStr)
-
-			// if there is an error opening the connection, handle it
-			if err != nil {
-				return nil, err
-			}
-
-			a, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
 			defer cancel()
-
-			err = db.PingContext(a)
-			if err != nil {
-				return nil, err
-			}
-			return db, nil
+			return cntest.OpenDB(ctx, Driver, dsn(cnt, cnt.Props[cntest.DBNameProp]))
 		}
 
-		dbOKFn1 := cnt.LogsMatch("received fast shutdown request")
-		dbOKFn2 := cnt.LogsMatch("database system is ready to accept connections")
-
-		cnt.ContainerReady = func() (bool, error) {
-
-			if running, err := cnt.IsRunning(); !running || err != nil {
-				if exited, err := cnt.IsExited(); exited || err != nil {
-					return false, err
-				} else {
-					return false, err
-				}
-			}
-			fmt.Printf("Attempting to connect to DB...")
-			db, err := cnt.DBConnect(1)
-			if err != nil {
-				if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "connection reset by peer") {
-					fmt.Printf("Connection refused\n")
-					return false, nil
-				}
-				if strings.Contains(err.Error(), "EOF") {
-					fmt.Printf("EOF\n")
-					return false, nil
-				}
-				fmt.Printf("Error %v\n%s\n", err, err.Error())
-				return false, err
-			}
-			defer db.Close()
-
-			fmt.Printf("Cheking logs...\n")
-			matches, err := dbOKFn1()
-			if err != nil {
-				fmt.Printf("not matched required log\n")
-				return false, err
+		cnt.ConnectCommands = func() []string {
+			dbName, dbUser, dbPass := cnt.Props[cntest.DBNameProp], cnt.Props[cntest.DBUserProp], cnt.Props[cntest.DBPassProp]
+			return []string{
+				fmt.Sprintf("psql postgres://%s:%s@127.0.0.1:%s/%s", dbUser, dbPass, cnt.HostPort(), dbName),
+				fmt.Sprintf("docker exec -it %s psql -U %s %s", cnt.ContainerName(), dbUser, dbName),
 			}
-			if matches {
-				matches, err = dbOKFn2()
-				if err != nil {
-					fmt.Printf("not matched required log\n")
-					return false, err
-				}
-
-			}
-			fmt.Printf("Container reachable\n")
-			return matches, nil
 		}
+
+		cnt.ContainerReady = containerReady(cnt)
 		return nil
 	}
 }
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/cybernostics/cntest"
+)
+
+// Log lines written by the official image as it starts
+const (
+	// initStarted is written by initdb when the entrypoint initialises a new db
+	initStarted = "The files belonging to this database system will be owned by"
+	// initComplete is written once the init scripts have run and the temporary server has stopped
+	initComplete = "PostgreSQL init process complete; ready for start up."
+	// serverReady is written by the temporary init server and the final server
+	serverReady = "database system is ready to accept connections"
+)
+
+// ReadyInLogs reports whether the logs show the final postgres server is accepting connections.
+// While the image initialises a new db it runs a temporary server, which also says it is ready,
+// so only a ready line after the init process completes counts then
+func ReadyInLogs(logs string) bool {
+	from := 0
+	if done := strings.LastIndex(logs, initComplete); done >= 0 {
+		from = done
+	} else if strings.Contains(logs, initStarted) {
+		return false
+	}
+	return strings.Contains(logs[from:], serverReady)
+}
+
+// containerReady waits for the final server in the logs and then for a query to work
+func containerReady(cnt *cntest.Container) cntest.ContainerReadyFn {
+	return func() (bool, error) {
+		if running, err := cnt.IsRunning(); !running || err != nil {
+			return false, err
+		}
+		logs, err := cnt.Logs()
+		if err != nil {
+			return false, err
+		}
+		if !ReadyInLogs(logs) {
+			return false, nil
+		}
+
+		db, err := cnt.DBConnect(1)
+		if err != nil {
+			return false, cntest.RetryableDBError(err)
+		}
+		defer db.Close()
+		if _, err = db.Exec("SELECT 1"); err != nil {
+			return false, cntest.RetryableDBError(err)
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,26 @@
+package postgres_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/postgres"
+)
+
+func TestReadyInLogs(t *testing.T) {
+	transcripts := map[string]bool{
+		"fresh-init.log":            true,
+		"running-init-scripts.log":  false,
+		"final-server-starting.log": false,
+		"existing-data.log":         true,
+	}
+	for file, ready := range transcripts {
+		t.Run(file, func(t *testing.T) {
+			logs, err := os.ReadFile("testdata/" + file)
+			then.AssertThat(t, err, is.Nil())
+			then.AssertThat(t, postgres.ReadyInLogs(string(logs)), is.EqualTo(ready))
+		})
+	}
+}
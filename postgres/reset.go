@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/migrate"
+	"github.com/lib/pq"
+)
+
+// Reset empties the tables in the instance database, restarting their sequences, then runs
+// any reload. The schema_migrations table and excluded tables keep their rows but
+// TRUNCATE CASCADE still empties excluded tables which refer to emptied ones
+func (i *Instance) Reset(ctx context.Context, opts ...cntest.ResetOption) error {
+	options := cntest.NewResetOptions(append([]cntest.ResetOption{cntest.ExcludeTables(migrate.Table)}, opts...)...)
+	db, err := i.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		if !options.Excludes(table) {
+			tables = append(tables, pq.QuoteIdentifier(table))
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(tables) > 0 {
+		_, err = db.ExecContext(ctx, "TRUNCATE TABLE "+strings.Join(tables, ", ")+" RESTART IDENTITY CASCADE")
+		if err != nil {
+			return err
+		}
+	}
+	if options.Reload != nil {
+		return options.Reload(ctx, db, Driver)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/cybernostics/cntest"
+	"github.com/cybernostics/cntest/random"
+	"github.com/lib/pq"
+)
+
+// maintenanceDB is the database connected to when creating and dropping other databases
+const maintenanceDB = "postgres"
+
+// MakeTemplate turns the instance database into a template for NewTestDatabase.
+// Call it once the init scripts and migrations have run. Nothing can connect to the
+// instance database afterwards, so use the test databases instead.
+// It is only done once and later calls do nothing
+func (i *Instance) MakeTemplate(ctx context.Context) error {
+	i.templateMu.Lock()
+	defer i.templateMu.Unlock()
+	return i.makeTemplate(ctx)
+}
+
+func (i *Instance) makeTemplate(ctx context.Context) error {
+	if len(i.template) > 0 {
+		return nil
+	}
+	db, err := i.openMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := pq.QuoteIdentifier(i.DBName())
+	statements := []string{
+		fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS false", name),
+		fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = %s AND pid <> pg_backend_pid()",
+			pq.QuoteLiteral(i.DBName())),
+		fmt.Sprintf("ALTER DATABASE %s WITH IS_TEMPLATE true", name),
+	}
+	for _, statement := range statements {
+		if _, err = db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("unable to make %s a template: %w", i.DBName(), err)
+		}
+	}
+	i.template = i.DBName()
+	return nil
+}
+
+// NewTestDatabase creates a database for this test cloned from the template and returns its DSN.
+// The template is made from the instance database on first use if MakeTemplate hasn't been called.
+// The database is dropped when the test finishes. It is safe to call from parallel tests
+func (i *Instance) NewTestDatabase(t testing.TB) string {
+	t.Helper()
+	ctx := context.Background()
+
+	i.templateMu.Lock()
+	if err := i.makeTemplate(ctx); err != nil {
+		i.templateMu.Unlock()
+		t.Fatalf("Unable to create test database: %v", err)
+	}
+	name := "test_" + random.Name()
+	err := i.clone(ctx, name)
+	i.templateMu.Unlock()
+	if err != nil {
+		t.Fatalf("Unable to create test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := i.drop(context.Background(), name); err != nil {
+			t.Errorf("Unable to drop test database %s: %v", name, err)
+		}
+	})
+	return dsn(i.Container, name)
+}
+
+// clone creates a database from the template
+func (i *Instance) clone(ctx context.Context, name string) error {
+	db, err := i.openMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		pq.QuoteIdentifier(name), pq.QuoteIdentifier(i.template)))
+	return err
+}
+
+// drop removes a test database, disconnecting anything still using it
+func (i *Instance) drop(ctx context.Context, name string) error {
+	db, err := i.openMaintenance(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", pq.QuoteIdentifier(name)))
+	return err
+}
+
+// openMaintenance connects to the postgres database as the instance user, who is a superuser
+func (i *Instance) openMaintenance(ctx context.Context) (*sql.DB, error) {
+	return cntest.OpenDB(ctx, Driver, dsn(i.Container, maintenanceDB))
+}
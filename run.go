@@ -0,0 +1,105 @@
+package cntest
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// RunResult is the outcome of a container run to completion
+type RunResult struct {
+	// ExitCode is the exit status of the container's main process
+	ExitCode int
+	// Stdout is everything the container wrote to stdout
+	Stdout string
+	// Stderr is everything the container wrote to stderr
+	Stderr string
+	// Duration is how long the container ran for
+	Duration time.Duration
+	// OOMKilled is true if the container was killed for running out of memory
+	OOMKilled bool
+}
+
+// RunToCompletion starts the container, waits for it to exit and returns its
+// exit code and output. Use it for one-shot containers like migration jobs,
+// CLI tools or batch steps. The container is removed afterwards if RemoveAfterTest is set.
+// If ctx is done before the container exits it is killed and ctx.Err() is returned.
+func (c *Container) RunToCompletion(ctx context.Context) (*RunResult, error) {
+	// Without a TTY docker keeps stdout and stderr apart so they can be demultiplexed
+	c.mu.Lock()
+	c.Config.Tty = false
+	c.mu.Unlock()
+
+	started := time.Now()
+	if _, err := c.Start(); err != nil {
+		return nil, err
+	}
+	if c.RemoveAfterTest {
+		defer func() {
+			_ = c.Remove()
+		}()
+	}
+
+	waitCh, errCh := API().ContainerWait(ctx, c.ID(), container.WaitConditionNotRunning)
+	var exitCode int
+	select {
+	case res := <-waitCh:
+		exitCode = int(res.StatusCode)
+		c.setState(Exited)
+	case err := <-errCh:
+		if ctx.Err() != nil {
+			c.expectedExit.Store(true)
+			if killErr := API().ContainerKill(context.Background(), c.ID(), "SIGKILL"); killErr == nil {
+				c.setState(Exited)
+			}
+		}
+		return nil, err
+	}
+
+	result := &RunResult{
+		ExitCode: exitCode,
+		Duration: time.Since(started),
+	}
+
+	inspect, err := API().ContainerInspect(ctx, c.ID())
+	if err != nil {
+		return result, err
+	}
+	result.OOMKilled = inspect.State.OOMKilled
+	if duration, ok := runDuration(inspect.State.StartedAt, inspect.State.FinishedAt); ok {
+		result.Duration = duration
+	}
+
+	logsReader, err := API().ContainerLogs(ctx, c.ID(), container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return result, err
+	}
+	defer logsReader.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err = stdcopy.StdCopy(&stdout, &stderr, logsReader); err != nil {
+		return result, err
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+// runDuration works out how long a container ran from the docker inspect timestamps
+func runDuration(startedAt string, finishedAt string) (time.Duration, bool) {
+	start, err := time.Parse(time.RFC3339Nano, startedAt)
+	if err != nil {
+		return 0, false
+	}
+	finish, err := time.Parse(time.RFC3339Nano, finishedAt)
+	if err != nil || finish.Before(start) {
+		return 0, false
+	}
+	return finish.Sub(start), true
+}
@@ -0,0 +1,66 @@
+package cntest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DataDirProp records where a db module has moved the server's data dir
+const DataDirProp = "datadir"
+
+// SettingArgsFn spells a server setting as command line args. eg "-c", "key=value" for postgres
+type SettingArgsFn func(key string, value string) []string
+
+// WithServerSettings adds the settings, in key order, to the command line of the server
+// started by command. Setting names can't be empty or contain '=' or spaces
+func WithServerSettings(command string, args SettingArgsFn, settings map[string]string) Option {
+	return func(c *Container) error {
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if len(key) == 0 || strings.ContainsAny(key, "= ") || strings.HasPrefix(key, "-") {
+				return fmt.Errorf("invalid %s setting name %q", command, key)
+			}
+			AddServerArgs(c, command, args(key, settings[key])...)
+		}
+		return nil
+	}
+}
+
+// AddServerArgs appends args to the container's command. If the image's default command
+// is being used it is replaced with command first, so the args go to the server
+func AddServerArgs(c *Container, command string, args ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.Config.Cmd) == 0 {
+		c.Config.Cmd = []string{command}
+	}
+	c.Config.Cmd = append(c.Config.Cmd, args...)
+}
+
+// SetDataDir records that the server keeps its data in dir. It fails if another option
+// has already moved it somewhere else, eg fast mode to a tmpfs and a snapshot to disk
+func SetDataDir(c *Container, dir string) error {
+	if current, ok := c.Props[DataDirProp]; ok && current != dir {
+		return fmt.Errorf("the data dir has already been moved to %s", current)
+	}
+	c.Props[DataDirProp] = dir
+	return nil
+}
+
+// WithTmpfs mounts an empty in memory filesystem at path. Its contents are lost when the container stops
+func WithTmpfs(path string) Option {
+	return func(c *Container) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.HostConfig.Tmpfs == nil {
+			c.HostConfig.Tmpfs = map[string]string{}
+		}
+		c.HostConfig.Tmpfs[path] = "rw"
+		return nil
+	}
+}
@@ -0,0 +1,105 @@
+package cntest
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// StopOptions control how a container is stopped
+type StopOptions struct {
+	// Signal asks the container to stop eg "SIGTERM" or "SIGINT".
+	// Defaults to the image's stop signal, which is usually SIGTERM
+	Signal string
+	// GracePeriod is how long the container has to exit before it is killed with SIGKILL.
+	// Docker works in whole seconds so this is rounded up. Zero uses the image's stop
+	// timeout, or the docker default of 10s
+	GracePeriod time.Duration
+}
+
+// StopResult describes how a container shut down
+type StopResult struct {
+	// ExitCode of the container's main process. 137 means it was killed
+	ExitCode int
+	// Duration is how long the container took to stop
+	Duration time.Duration
+	// Logs are the container logs written while it was shutting down
+	Logs string
+}
+
+// StopWith stops the container as the options say and reports how it went
+// The PreStop and PostStop hooks are run if the container was running. The container is
+// stopped even if a PreStop hook fails, and the hook error is returned
+func (c *Container) StopWith(ctx context.Context, opts StopOptions) (*StopResult, error) {
+	id, err := c.id()
+	if err != nil {
+		return nil, err
+	}
+	wasRunning := c.State() == Running || c.State() == Ready
+	var hookErr error
+	if wasRunning {
+		hookErr = c.runHooks(ctx, "PreStop", c.Hooks.PreStop)
+	}
+	stopOptions := container.StopOptions{Signal: opts.Signal}
+	if opts.GracePeriod > 0 {
+		graceSeconds := int((opts.GracePeriod + time.Second - 1) / time.Second)
+		stopOptions.Timeout = &graceSeconds
+	}
+	c.expectedExit.Store(true)
+	started := time.Now()
+	err = API().ContainerStop(ctx, id, stopOptions)
+	if err != nil {
+		return nil, err
+	}
+	c.setState(Exited)
+	if wasRunning && hookErr == nil {
+		hookErr = c.runHooks(ctx, "PostStop", c.Hooks.PostStop)
+	}
+
+	result := &StopResult{Duration: time.Since(started)}
+	inspect, err := API().ContainerInspect(ctx, id)
+	if err != nil {
+		return result, err
+	}
+	result.ExitCode = inspect.State.ExitCode
+	if finished, err := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt); err == nil && finished.After(started) {
+		result.Duration = finished.Sub(started)
+	}
+
+	result.Logs, err = c.logs(container.LogsOptions{
+		ShowStderr: true,
+		ShowStdout: true,
+		Since:      fmt.Sprintf("%d.%09d", started.Unix(), started.Nanosecond()),
+	})
+	if hookErr != nil {
+		return result, hookErr
+	}
+	return result, err
+}
+
+// AssertGracefulShutdown sends SIGTERM to the container and fails the test unless it exits
+// with code 0 within the time allowed, logging lines matching each of the logPatterns on the way
+func AssertGracefulShutdown(t testing.TB, c *Container, within time.Duration, logPatterns ...string) *StopResult {
+	t.Helper()
+	result, err := c.StopWith(context.Background(), StopOptions{Signal: "SIGTERM", GracePeriod: within})
+	if err != nil {
+		t.Errorf("Couldn't stop container %s: %v", c.ContainerName(), err)
+		return result
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("Container %s exited with code %d on SIGTERM, expected 0", c.ContainerName(), result.ExitCode)
+	}
+	if result.Duration > within {
+		t.Errorf("Container %s took %v to shut down, expected it within %v", c.ContainerName(), result.Duration, within)
+	}
+	for _, pattern := range logPatterns {
+		if !regexp.MustCompile(pattern).MatchString(result.Logs) {
+			t.Errorf("Container %s didn't log %q while shutting down. Shutdown logs were:\n%s", c.ContainerName(), pattern, result.Logs)
+		}
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package cntest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// SnapshotRepo is the local image repository snapshots are committed to
+const SnapshotRepo = "cntest-snapshot"
+
+// WithSnapshot commits the container to a local image once it is ready, so later runs
+// start from the snapshot instead of initialising again. The snapshot is keyed by a hash
+// of the image, env, command, the contents of bind mounted host paths and any extra keys,
+// so changing any of them makes a new one.
+// PostReady hooks added before WithSnapshot run before the snapshot is taken and are
+// skipped when starting from it. Hooks added after it run every time.
+// Docker doesn't commit volumes, so the data to keep must not be under a VOLUME path of the image.
+func WithSnapshot(keys ...string) Option {
+	return func(c *Container) error {
+		before := len(c.Hooks.PostReady)
+		// worked out on the first Start so a retried Start gets the same result
+		var tag, image string
+		var postReady []HookFn
+		fromSnapshot := false
+		c.Hooks.PreCreate = append(c.Hooks.PreCreate, func(ctx context.Context, c *Container) error {
+			if tag == "" {
+				key, err := c.snapshotKey(ctx, keys)
+				if err != nil {
+					return err
+				}
+				tag = SnapshotRepo + ":" + key
+				image = c.Config.Image
+				postReady = c.Hooks.PostReady
+			}
+			fromSnapshot = false
+			c.WithImage(image)
+			c.Hooks.PostReady = postReady
+			if _, _, err := API().ImageInspectWithRaw(ctx, tag); err != nil {
+				fmt.Printf("No snapshot %s for %s yet\n", tag, c.ContainerName())
+				return nil
+			}
+			fmt.Printf("Starting %s from snapshot %s\n", c.ContainerName(), tag)
+			fromSnapshot = true
+			c.WithImage(tag)
+			c.Hooks.PostReady = postReady[before:]
+			return nil
+		})
+		c.Hooks.PostReady = append(c.Hooks.PostReady, func(ctx context.Context, c *Container) error {
+			if fromSnapshot {
+				return nil
+			}
+			return c.Snapshot(ctx, tag)
+		})
+		return nil
+	}
+}
+
+// Snapshot commits the container's filesystem to a local image called ref
+// The container is paused while it is committed
+func (c *Container) Snapshot(ctx context.Context, ref string) error {
+	id, err := c.id()
+	if err != nil {
+		return err
+	}
+	_, err = API().ContainerCommit(ctx, id, container.CommitOptions{
+		Reference: ref,
+		Comment:   "cntest snapshot of " + c.ContainerName(),
+		Pause:     true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to snapshot %s: %w", c.ContainerName(), err)
+	}
+	fmt.Printf("Saved snapshot %s of %s\n", ref, c.ContainerName())
+	return nil
+}
+
+// snapshotKey hashes everything that changes what the initialised container looks like
+func (c *Container) snapshotKey(ctx context.Context, keys []string) (string, error) {
+	c.mu.RLock()
+	image := c.Config.Image
+	env := append([]string{}, c.Config.Env...)
+	cmd := append([]string{}, c.Config.Cmd...)
+	entrypoint := append([]string{}, c.Config.Entrypoint...)
+	mounts := append([]mount.Mount{}, c.HostConfig.Mounts...)
+	c.mu.RUnlock()
+
+	hash := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			fmt.Fprintf(hash, "%d:%s\n", len(part), part)
+		}
+	}
+	write("image", image)
+	// the tag may point at a newer image
+	if inspect, _, err := API().ImageInspectWithRaw(ctx, image); err == nil {
+		write(inspect.ID)
+	}
+	sort.Strings(env)
+	write("env")
+	write(env...)
+	write("cmd")
+	write(cmd...)
+	write("entrypoint")
+	write(entrypoint...)
+	write("keys")
+	write(keys...)
+	for _, m := range mounts {
+		if m.Type != mount.TypeBind {
+			continue
+		}
+		write("mount", m.Target)
+		if err := hashPath(hash, m.Source); err != nil {
+			return "", fmt.Errorf("unable to hash %s for the snapshot key: %w", m.Source, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// hashPath writes the names and contents of the files under root to the hash
+func hashPath(hash io.Writer, root string) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fmt.Fprintf(hash, "file:%s\n", filepath.ToSlash(name))
+		_, err = io.Copy(hash, file)
+		return err
+	})
+}
@@ -0,0 +1,120 @@
+package cntest
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is where a container is in its lifecycle
+type State int
+
+const (
+	// Configured containers have not been created in docker yet
+	Configured State = iota
+	// Created containers exist in docker but have not been started
+	Created
+	// Running containers have started but may not be ready to use yet
+	Running
+	// Ready containers have passed their ContainerReady check
+	Ready
+	// Paused containers have had their processes frozen by Pause
+	Paused
+	// Exited containers have stopped running
+	Exited
+	// Removed containers have been deleted from docker
+	Removed
+)
+
+var stateNames = []string{"Configured", "Created", "Running", "Ready", "Paused", "Exited", "Removed"}
+
+func (s State) String() string {
+	if s < Configured || s > Removed {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// ErrNotStarted is returned when a container is used before Start is called
+var ErrNotStarted = errors.New("container has not been started")
+
+// ErrAlreadyStarted is returned when Start is called on a container more than once
+var ErrAlreadyStarted = errors.New("container has already been started")
+
+// ErrRemoved is returned when a container is used after it has been removed
+var ErrRemoved = errors.New("container has been removed")
+
+// StateChangeFn is called after the container moves from one state to another
+type StateChangeFn func(c *Container, from State, to State)
+
+// State returns where the container is in its lifecycle
+func (c *Container) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// OnStateChange adds a fn called on every state change, in the order they were added
+// The fns are called on the goroutine that caused the change
+func (c *Container) OnStateChange(fn StateChangeFn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stateChangeFns = append(c.stateChangeFns, fn)
+}
+
+// setState moves the container to a new state and lets the hooks know
+func (c *Container) setState(to State) {
+	c.changeState(func(State) State {
+		return to
+	})
+}
+
+// changeState moves the container to the state picked by next and lets the hooks know
+// The hooks are called without holding the lock so they can use the container
+func (c *Container) changeState(next func(from State) State) {
+	c.mu.Lock()
+	from := c.state
+	to := next(from)
+	if from == to {
+		c.mu.Unlock()
+		return
+	}
+	c.state = to
+	fns := c.stateChangeFns
+	c.mu.Unlock()
+	for _, fn := range fns {
+		fn(c, from, to)
+	}
+}
+
+// observe updates the state from the docker status of the container
+func (c *Container) observe(status string) {
+	c.changeState(func(from State) State {
+		switch status {
+		case "created":
+			return Created
+		case "running", "restarting":
+			// docker can't tell running from ready, so only move forward
+			if from != Ready {
+				return Running
+			}
+		case "paused":
+			return Paused
+		case "exited", "dead":
+			return Exited
+		}
+		return from
+	})
+}
+
+// id returns the docker id of the container or an error if it doesn't exist in docker
+func (c *Container) id() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch c.state {
+	case Configured:
+		return "", ErrNotStarted
+	case Removed:
+		return "", ErrRemoved
+	}
+	return c.Instance.ID, nil
+}
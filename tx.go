@@ -0,0 +1,90 @@
+package cntest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TestTx is a transaction which is always rolled back when the test ends
+// so every test starts from the same data
+type TestTx struct {
+	*sqlx.Tx
+
+	mu         sync.Mutex
+	savepoints int
+}
+
+// Savepoint is a nested transaction inside a TestTx
+type Savepoint struct {
+	tx   *TestTx
+	name string
+	done bool
+}
+
+// BeginTestTx connects to the db with DBConnect and begins a transaction which is
+// rolled back and closed when the test ends. Use tx.Tx.Tx if you need the *sql.Tx
+func (c *Container) BeginTestTx(t testing.TB) *TestTx {
+	t.Helper()
+	if c.DBConnect == nil {
+		t.Fatalf("Container %s has no DBConnect fn", c.ContainerName())
+	}
+	db, err := c.DBConnect(c.MaxStartTimeSeconds)
+	if err != nil {
+		t.Fatalf("Unable to connect to %s: %v", c.ContainerName(), err)
+	}
+	tx, err := sqlx.NewDb(db, c.Props[DriverProp]).Beginx()
+	if err != nil {
+		db.Close()
+		t.Fatalf("Unable to begin a transaction on %s: %v", c.ContainerName(), err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("Unable to roll back the test transaction: %v", err)
+		}
+		db.Close()
+	})
+	return &TestTx{Tx: tx}
+}
+
+// Savepoint starts a nested transaction, for code under test which begins its own.
+// Savepoints can be nested too. Commit or Rollback it like a normal transaction
+func (tx *TestTx) Savepoint(ctx context.Context) (*Savepoint, error) {
+	tx.mu.Lock()
+	tx.savepoints++
+	name := fmt.Sprintf("cntest_savepoint_%d", tx.savepoints)
+	tx.mu.Unlock()
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &Savepoint{tx: tx, name: name}, nil
+}
+
+// Commit keeps the changes made since the savepoint in the test transaction
+func (s *Savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.tx.Exec("RELEASE SAVEPOINT " + s.name)
+	return err
+}
+
+// Rollback undoes the changes made since the savepoint
+// Like sql.Tx it returns sql.ErrTxDone after Commit, so it can be deferred
+func (s *Savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	if _, err := s.tx.Exec("ROLLBACK TO SAVEPOINT " + s.name); err != nil {
+		return err
+	}
+	_, err := s.tx.Exec("RELEASE SAVEPOINT " + s.name)
+	return err
+}
@@ -0,0 +1,223 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// DefaultInterval is the delay between attempts unless overridden with Interval
+const DefaultInterval = 500 * time.Millisecond
+
+// errNotReady is the retryable error Until uses for a false result
+var errNotReady = errors.New("not ready")
+
+// Option customises how For polls
+type Option func(*policy)
+
+// policy holds the polling settings for a single wait
+type policy struct {
+	timeout     time.Duration
+	interval    time.Duration
+	maxInterval time.Duration
+	multiplier  float64
+	jitter      float64
+	failFast    bool
+}
+
+// Timeout gives up after d. Without it the wait lasts as long as the context
+func Timeout(d time.Duration) Option {
+	return func(p *policy) {
+		p.timeout = d
+	}
+}
+
+// Interval sets the delay between attempts. Defaults to DefaultInterval
+func Interval(d time.Duration) Option {
+	return func(p *policy) {
+		p.interval = d
+	}
+}
+
+// Backoff multiplies the delay by multiplier after every attempt, up to maxInterval
+func Backoff(multiplier float64, maxInterval time.Duration) Option {
+	return func(p *policy) {
+		p.multiplier = multiplier
+		p.maxInterval = maxInterval
+	}
+}
+
+// Jitter randomly varies each delay by up to +/- fraction of itself, eg 0.2 for 20%
+func Jitter(fraction float64) Option {
+	return func(p *policy) {
+		p.jitter = fraction
+	}
+}
+
+// FailFast treats any error not marked Retryable as fatal
+// This is how UntilTrue has always behaved
+func FailFast() Option {
+	return func(p *policy) {
+		p.failFast = true
+	}
+}
+
+// fatalError marks an error which should stop the wait
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string { return e.err.Error() }
+func (e *fatalError) Unwrap() error { return e.err }
+
+// retryableError marks an error which is worth trying again
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Fatal marks err so the wait stops immediately and returns it
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err}
+}
+
+// Retryable marks err so the wait keeps trying, even with FailFast
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err}
+}
+
+// IsFatal returns true if err was marked with Fatal
+func IsFatal(err error) bool {
+	var fatal *fatalError
+	return errors.As(err, &fatal)
+}
+
+// IsRetryable returns true if err was marked with Retryable
+func IsRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable)
+}
+
+// For calls fn until it succeeds and returns the value it produced.
+// The first attempt happens straight away. Errors are retried unless marked Fatal
+// (or FailFast is set and they aren't marked Retryable), in which case the error is returned.
+// If we run out of time a *TimeoutError is returned holding the last error from fn.
+func For[T any](ctx context.Context, fn func(ctx context.Context) (T, error), opts ...Option) (T, error) {
+	p := policy{interval: DefaultInterval, multiplier: 1}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	attempts := 0
+	delay := p.interval
+	var lastErr error
+	var zero T
+	for {
+		attempts++
+		value, err := fn(ctx)
+		if err == nil {
+			return value, nil
+		}
+		if IsFatal(err) || (p.failFast && !IsRetryable(err)) {
+			return zero, unmark(err)
+		}
+		if err = unmark(err); err != errNotReady {
+			lastErr = err
+		}
+
+		timer := time.NewTimer(p.jittered(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return zero, &TimeoutError{Attempts: attempts, Elapsed: time.Since(started), LastErr: lastErr}
+			}
+			return zero, ctx.Err()
+		case <-timer.C:
+		}
+		delay = p.next(delay)
+	}
+}
+
+// Until calls fn until it returns true, with the same rules as For
+func Until(ctx context.Context, fn func(ctx context.Context) (bool, error), opts ...Option) error {
+	_, err := For(ctx, func(ctx context.Context) (bool, error) {
+		ok, err := fn(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, Retryable(errNotReady)
+		}
+		return true, nil
+	}, opts...)
+	return err
+}
+
+// Done runs fn in the background and waits for it to return or for ctx to be done.
+// fn is given the same ctx so it can give up too, rather than being left hanging
+func Done(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	started := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- fn(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &TimeoutError{Attempts: 1, Elapsed: time.Since(started)}
+		}
+		return ctx.Err()
+	}
+}
+
+// next works out the delay before the following attempt
+func (p *policy) next(delay time.Duration) time.Duration {
+	if p.multiplier <= 1 {
+		return delay
+	}
+	next := time.Duration(float64(delay) * p.multiplier)
+	if p.maxInterval > 0 && next > p.maxInterval {
+		return p.maxInterval
+	}
+	return next
+}
+
+// jittered spreads the delay so lots of waiters don't poll in lockstep
+func (p *policy) jittered(delay time.Duration) time.Duration {
+	if p.jitter <= 0 {
+		return delay
+	}
+	spread := float64(delay) * p.jitter
+	return delay + time.Duration(spread*(2*rand.Float64()-1))
+}
+
+// unmark strips the Fatal or Retryable marker so callers see the original error
+func unmark(err error) error {
+	switch marked := err.(type) {
+	case *fatalError:
+		return marked.err
+	case *retryableError:
+		return marked.err
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package wait_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/wait"
+)
+
+func TestForReturnsValueOnceReady(t *testing.T) {
+	calls := 0
+	value, err := wait.For(context.Background(), func(context.Context) (string, error) {
+		calls++
+		if calls < 3 {
+			return "", errors.New("connection refused")
+		}
+		return "db", nil
+	}, wait.Interval(time.Millisecond))
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, value, is.EqualTo("db"))
+	then.AssertThat(t, calls, is.EqualTo(3))
+}
+
+func TestForFirstAttemptIsImmediate(t *testing.T) {
+	started := time.Now()
+	_, err := wait.For(context.Background(), func(context.Context) (int, error) {
+		return 1, nil
+	}, wait.Interval(time.Hour))
+	then.AssertThat(t, err, is.Nil())
+	then.AssertThat(t, time.Since(started), is.LessThan(time.Second))
+}
+
+func TestForStopsOnFatalError(t *testing.T) {
+	exited := errors.New("container exited")
+	calls := 0
+	_, err := wait.For(context.Background(), func(context.Context) (int, error) {
+		calls++
+		return 0, wait.Fatal(exited)
+	}, wait.Interval(time.Millisecond))
+	then.AssertThat(t, err, is.EqualTo(exited))
+	then.AssertThat(t, calls, is.EqualTo(1))
+}
+
+func TestForFailFastRetriesOnlyRetryableErrors(t *testing.T) {
+	refused := errors.New("connection refused")
+	broken := errors.New("bad password")
+	calls := 0
+	_, err := wait.For(context.Background(), func(context.Context) (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, wait.Retryable(refused)
+		}
+		return 0, broken
+	}, wait.Interval(time.Millisecond), wait.FailFast())
+	then.AssertThat(t, err, is.EqualTo(broken))
+	then.AssertThat(t, calls, is.EqualTo(2))
+}
+
+func TestForTimeoutKeepsLastError(t *testing.T) {
+	refused := errors.New("connection refused")
+	_, err := wait.For(context.Background(), func(context.Context) (int, error) {
+		return 0, refused
+	}, wait.Timeout(50*time.Millisecond), wait.Interval(5*time.Millisecond), wait.Backoff(2, 20*time.Millisecond), wait.Jitter(0.2))
+
+	var timeoutErr *wait.TimeoutError
+	then.AssertThat(t, errors.As(err, &timeoutErr), is.True())
+	then.AssertThat(t, timeoutErr.LastErr, is.EqualTo(refused))
+	then.AssertThat(t, timeoutErr.Attempts, is.GreaterThan(1))
+}
+
+func TestUntilCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := wait.Until(ctx, func(context.Context) (bool, error) {
+		return false, nil
+	})
+	then.AssertThat(t, err, is.EqualTo(context.Canceled))
+}
+
+func TestDoneGivesUpWithTheProducer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	producerDone := make(chan struct{})
+	err := wait.Done(ctx, func(ctx context.Context) error {
+		defer close(producerDone)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	then.AssertThat(t, errors.Is(err, wait.ErrTimeout), is.True())
+	select {
+	case <-producerDone:
+	case <-time.After(time.Second):
+		t.Fatal("producer was left running")
+	}
+}
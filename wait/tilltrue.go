@@ -1,35 +1,55 @@
 package wait
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
+// ErrTimeout is matched by errors.Is for any timeout returned by this package
+var ErrTimeout = errors.New("timed out")
+
+// TimeoutError is returned when the wait times out
+type TimeoutError struct {
+	// Attempts is the number of times the condition was checked
+	Attempts int
+	// Elapsed is how long we waited
+	Elapsed time.Duration
+	// LastErr is the last retryable error seen before we gave up, if any
+	LastErr error
+}
+
+func (e *TimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out after %v (%d attempts)", e.Elapsed.Round(time.Millisecond), e.Attempts)
+	if e.LastErr != nil {
+		msg = fmt.Sprintf("%s: last error: %v", msg, e.LastErr)
+	}
+	return msg
+}
+
+// Is lets errors.Is match ErrTimeout
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
 // UntilTrue call the fn every 500 millis until it errors, returns or a timeout
+// Errors stop the wait unless they are marked Retryable.
+// Prefer Until or For which take a context and durations.
 func UntilTrue(timeoutSeconds int, fn func() (bool, error)) (bool, error) {
-	timeout := time.After(time.Duration(timeoutSeconds) * time.Second)
-	tick := time.NewTicker(500 * time.Millisecond)
-	// Keep trying until we're timed out or got a result or got an error
-	for {
-		select {
-		// Got a timeout! fail with a timeout error
-		case <-timeout:
-			return false, errors.New("timed out")
-		// Got a tick, we should check on doSomething()
-		case <-tick.C:
-			result, err := fn()
-			if err != nil {
-				return false, err
-			}
-			if result {
-				return result, nil
-			}
-		}
+	err := Until(context.Background(), func(context.Context) (bool, error) {
+		return fn()
+	}, Timeout(time.Duration(timeoutSeconds)*time.Second), FailFast())
+	if err != nil {
+		return false, err
 	}
+	return true, nil
 }
 
 // UntilDone Wait for the process to finish, error or timeout
+// Prefer Done which lets the process know when to give up.
 func UntilDone(timeoutSeconds int, fn func() (chan bool, chan error)) (bool, error) {
+	started := time.Now()
 	timeout := time.After(time.Duration(timeoutSeconds) * time.Second)
 	doneChan, errChan := fn()
 	// Keep trying until we're timed out or got a result or got an error
@@ -37,7 +57,14 @@ func UntilDone(timeoutSeconds int, fn func() (chan bool, chan error)) (bool, err
 		select {
 		// Got a timeout! fail with a timeout error
 		case <-timeout:
-			return false, errors.New("timed out")
+			// take the eventual result so the producer isn't left blocked sending it
+			go func() {
+				select {
+				case <-doneChan:
+				case <-errChan:
+				}
+			}()
+			return false, &TimeoutError{Attempts: 1, Elapsed: time.Since(started)}
 		// Got a result
 		case result := <-doneChan:
 			return result, nil
@@ -0,0 +1,25 @@
+package wait_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/corbym/gocrest/is"
+	"github.com/corbym/gocrest/then"
+	"github.com/cybernostics/cntest/wait"
+)
+
+func TestUntilTrueTimeoutReportsAttempts(t *testing.T) {
+	calls := 0
+	ok, err := wait.UntilTrue(1, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	then.AssertThat(t, ok, is.False())
+	then.AssertThat(t, errors.Is(err, wait.ErrTimeout), is.True())
+
+	var timeoutErr *wait.TimeoutError
+	then.AssertThat(t, errors.As(err, &timeoutErr), is.True())
+	then.AssertThat(t, timeoutErr.Attempts, is.EqualTo(calls))
+	then.AssertThat(t, timeoutErr.Attempts, is.GreaterThan(0))
+}
@@ -0,0 +1,118 @@
+package cntest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// WatchLogTailLines is the number of log lines reported when a watched container dies
+var WatchLogTailLines = 20
+
+// ContainerExitError describes a container that died while a test was using it
+type ContainerExitError struct {
+	// Name of the container that died
+	Name string
+	// ExitCode reported by docker for the container's main process
+	ExitCode string
+	// OOMKilled is true if the container ran out of memory
+	OOMKilled bool
+	// LogTail holds the last few lines of the container logs
+	LogTail string
+}
+
+func (e *ContainerExitError) Error() string {
+	return fmt.Sprintf("container %s died unexpectedly (exit code %s, OOMKilled %t). Last logs:\n%s",
+		e.Name, e.ExitCode, e.OOMKilled, e.LogTail)
+}
+
+// WatchForExit subscribes to the docker event stream for this container and fails t
+// if the container dies or is OOM killed while the test is running.
+// The returned context is cancelled when that happens so the test can bail out early.
+// Call the returned stop fn before the test ends. Exits caused by Stop, Remove, Kill or Restart are ignored.
+func WatchForExit(ctx context.Context, t testing.TB, c *Container) (context.Context, func()) {
+	testCtx, cancelTest := context.WithCancel(ctx)
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+
+	eventFilters := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("container", c.ID()),
+		filters.Arg("label", LabelKey),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionOOM)),
+	)
+	msgs, errs := API().Events(watchCtx, types.EventsOptions{Filters: eventFilters})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		oomKilled := false
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case err := <-errs:
+				// the stream also ends when we stop watching
+				if watchCtx.Err() == nil {
+					t.Errorf("Stopped watching %s for unexpected exits as the docker events stream failed: %v", c.ContainerName(), err)
+				}
+				return
+			case msg := <-msgs:
+				if msg.Action == events.ActionOOM {
+					oomKilled = true
+					continue
+				}
+				// ignore exits we caused and ones from before a restart
+				if c.expectedExit.Load() || msg.TimeNano < c.lastStarted.Load() {
+					continue
+				}
+				c.setState(Exited)
+				exitErr := c.exitError(msg.Actor.Attributes["exitCode"], oomKilled)
+				t.Errorf("%v", exitErr)
+				cancelTest()
+				return
+			}
+		}
+	}()
+
+	return testCtx, func() {
+		cancelWatch()
+		wg.Wait()
+		cancelTest()
+	}
+}
+
+// exitError gathers what we know about why the container died
+func (c *Container) exitError(exitCode string, oomKilled bool) *ContainerExitError {
+	exitErr := &ContainerExitError{
+		Name:      c.ContainerName(),
+		ExitCode:  exitCode,
+		OOMKilled: oomKilled,
+	}
+	if inspect, err := API().ContainerInspect(context.Background(), c.ID()); err == nil {
+		exitErr.OOMKilled = exitErr.OOMKilled || inspect.State.OOMKilled
+		if exitErr.ExitCode == "" {
+			exitErr.ExitCode = fmt.Sprintf("%d", inspect.State.ExitCode)
+		}
+	}
+	if tail, err := c.LogTail(WatchLogTailLines); err == nil {
+		exitErr.LogTail = tail
+	}
+	return exitErr
+}
+
+// LogTail returns the last n lines of the container logs
+func (c *Container) LogTail(lines int) (string, error) {
+	return c.logs(container.LogsOptions{
+		ShowStderr: true,
+		ShowStdout: true,
+		Tail:       fmt.Sprintf("%d", lines),
+	})
+}